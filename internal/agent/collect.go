@@ -2,114 +2,67 @@ package agent
 
 import (
 	"context"
-	"math/rand"
+	"encoding/json"
 	"sync"
 	"time"
 
-	"reflect"
-
+	"github.com/esafronov/yp-metrics/internal/agent/collector"
+	"github.com/esafronov/yp-metrics/internal/logger"
 	"github.com/esafronov/yp-metrics/internal/storage"
+	"go.uber.org/zap"
 )
 
-// Routine for collecting general metrics, returns channel for reading
-func (a *Agent) collectMemStat(ctx context.Context, pollInterval *int) chan storage.Metrics {
-	ch := make(chan storage.Metrics, 20)
-	ticker := time.NewTicker(time.Duration(*pollInterval) * time.Second)
-	go func() {
-		defer close(ch)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				a.ReadStat()
-				r := reflect.ValueOf(a.memStats)
-				for _, metricName := range storage.GetGaugeMetrics() {
-					rv := r.FieldByName(string(metricName))
-					if !rv.IsValid() {
-						continue
-					}
-					var v interface{}
-					if rv.CanUint() {
-						v = float64(rv.Uint())
-					} else if rv.CanFloat() {
-						v = rv.Float()
-					}
-					ch <- storage.Metrics{
-						ID:          string(metricName),
-						MType:       string(storage.MetricTypeGauge),
-						ActualValue: v,
-					}
-				}
-				ch <- storage.Metrics{
-					ID:          string(storage.MetricNamePollCount),
-					MType:       string(storage.MetricTypeCounter),
-					ActualValue: int64(1),
-				}
-				rn := rand.New(rand.NewSource(time.Now().UnixNano()))
-				ch <- storage.Metrics{
-					ID:          string(storage.MetricNameRandomValue),
-					MType:       string(storage.MetricTypeGauge),
-					ActualValue: rn.Float64(),
-				}
-			}
-		}
-	}()
-	return ch
+// registry returns collectors available to agent, built-in collectors read stat with agent read functions
+func (a *Agent) registry() *collector.Registry {
+	r := collector.NewRegistry()
+	r.Register(collector.NameMemStat, func(base collector.Base, _ json.RawMessage) (collector.Collector, error) {
+		return collector.NewMemStat(base, a.memReadFunc), nil
+	}, true)
+	r.Register(collector.NameSystem, func(base collector.Base, _ json.RawMessage) (collector.Collector, error) {
+		return collector.NewSystem(base, a.vmemReadFunc, a.cpuReadFunc, a.cpuTimesFunc), nil
+	}, true)
+	r.Register(collector.NameDisk, collector.NewDisk, false)
+	r.Register(collector.NameNet, collector.NewNet, false)
+	r.Register(collector.NameHost, collector.NewHost, false)
+	r.Register(collector.NameProcess, collector.NewProcess, false)
+	r.Register(collector.NameCgroup, collector.NewCgroup, false)
+	r.Register(collector.NameRuntime, collector.NewRuntime, false)
+	r.Register(collector.NameExec, collector.NewExec, false)
+	return r
 }
 
-// Routine for collecting extra gauge metrics, returns channel for reading them
-func (a *Agent) collectExtraStat(ctx context.Context, pollInterval *int) chan storage.Metrics {
-	ch := make(chan storage.Metrics, 20)
-	ticker := time.NewTicker(time.Duration(*pollInterval) * time.Second)
-	go func() {
-		defer close(ch)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				vmem, err := a.vmemReadFunc()
-				if err != nil {
-					panic("Error when reading mem")
-				}
-				ch <- storage.Metrics{
-					ID:          string(storage.MetricNameTotalMemory),
-					MType:       string(storage.MetricTypeGauge),
-					ActualValue: float64(vmem.Total),
-				}
-				ch <- storage.Metrics{
-					ID:          string(storage.MetricNameFreeMemory),
-					MType:       string(storage.MetricTypeGauge),
-					ActualValue: float64(vmem.Free),
-				}
-				vcpu, err := a.cpuReadFunc(0, false)
-				if err != nil {
-					panic("Error when reading cpu")
-				}
-				ch <- storage.Metrics{
-					ID:          string(storage.MetricNameCPUutilization1),
-					MType:       string(storage.MetricTypeGauge),
-					ActualValue: float64(vcpu[0]),
-				}
-			}
-		}
-	}()
-	return ch
+// SetupCollectors creates collectors enabled by configs, pollInterval is used for collectors without own interval
+func (a *Agent) SetupCollectors(configs map[string]collector.Config, pollInterval time.Duration) error {
+	collectors, err := a.registry().Build(configs, pollInterval)
+	if err != nil {
+		return err
+	}
+	a.collectors = collectors
+	return nil
 }
 
-// CollectMetrics run 2 routines for unit collected metrics from two channels into one
+// CollectMetrics run routine for every collector and unit collected metrics from their channels into one,
+// built-in collectors are used if collectors are not set up
 func (a *Agent) CollectMetrics(ctx context.Context, pollInterval *int) {
+	collectors := a.collectors
+	if collectors == nil {
+		var err error
+		collectors, err = a.registry().Build(nil, time.Duration(*pollInterval)*time.Second)
+		if err != nil {
+			logger.Log.Error("build collectors", zap.Error(err))
+		}
+	}
 	var wg sync.WaitGroup
-	wg.Add(2)
+	wg.Add(len(collectors))
 	processCh := func(c chan storage.Metrics) {
 		for data := range c {
 			a.chUpdate <- data
 		}
 		wg.Done()
 	}
-	go processCh(a.collectMemStat(ctx, pollInterval))
-	go processCh(a.collectExtraStat(ctx, pollInterval))
+	for _, c := range collectors {
+		go processCh(collector.Run(ctx, c))
+	}
 	go func() {
 		wg.Wait()
 		close(a.chUpdate)
@@ -124,7 +77,8 @@ func (a *Agent) UpdateMetrics(ctx context.Context) {
 			if metric != nil {
 				err := a.storage.Update(ctx, storage.MetricName(m.ID), m.ActualValue, metric)
 				if err != nil {
-					panic(err.Error())
+					//metric with same name and other type may be pushed by application
+					logger.Log.Error("update metric", zap.String("name", m.ID), zap.Error(err))
 				}
 			} else {
 				switch m.MType {
@@ -8,6 +8,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/esafronov/yp-metrics/internal/agent/collector"
+	"github.com/esafronov/yp-metrics/internal/agent/config"
 	"github.com/esafronov/yp-metrics/internal/storage"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/stretchr/testify/require"
@@ -47,11 +49,9 @@ func TestAgent_UpdateMetrics(t *testing.T) {
 		{
 			name: "Update Lookups=123 in storage to Lookups=456",
 			a: &Agent{
-				storage: &storage.MemStorage{
-					Values: map[storage.MetricName]storage.Metric{
-						"Lookups": storage.NewMetricGauge(float64(123)),
-					},
-				},
+				storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+					"Lookups": storage.NewMetricGauge(float64(123)),
+				}),
 				chUpdate: make(chan storage.Metrics),
 			},
 			metrics: []storage.Metrics{
@@ -70,11 +70,9 @@ func TestAgent_UpdateMetrics(t *testing.T) {
 			name: "Update PollCount=1 in storage to PollCount=2",
 			a: &Agent{
 				memStats: runtime.MemStats{Lookups: uint64(456)},
-				storage: &storage.MemStorage{
-					Values: map[storage.MetricName]storage.Metric{
-						"PollCount": storage.NewMetricCounter(int64(1)),
-					},
-				},
+				storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+					"PollCount": storage.NewMetricCounter(int64(1)),
+				}),
 				chUpdate: make(chan storage.Metrics),
 			},
 			metrics: []storage.Metrics{
@@ -167,7 +165,7 @@ func TestAgent_collectMemStat(t *testing.T) {
 					m.Alloc = uint64(101)
 				},
 			}
-			ch := a.collectMemStat(ctx, &pollInterval)
+			ch := collector.Run(ctx, collector.NewMemStat(collector.NewBase(collector.NameMemStat, time.Duration(pollInterval)*time.Second), a.memReadFunc))
 			time.Sleep(time.Duration(1500) * time.Millisecond)
 			cancel()
 			var got []storage.Metrics
@@ -218,7 +216,7 @@ func TestAgent_collectExtraStat(t *testing.T) {
 					return []float64{20}, nil
 				},
 			}
-			ch := a.collectExtraStat(ctx, &pollInterval)
+			ch := collector.Run(ctx, collector.NewSystem(collector.NewBase(collector.NameSystem, time.Duration(pollInterval)*time.Second), a.vmemReadFunc, a.cpuReadFunc, nil))
 			time.Sleep(time.Duration(1500) * time.Millisecond)
 			cancel()
 			var got []storage.Metrics
@@ -292,3 +290,34 @@ func TestAgent_collectMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestAgent_collectorConfigs(t *testing.T) {
+	enabled := true
+	list := "memstat, cpu"
+	empty := ""
+	names := []string{"memstat", "system"}
+
+	configs := collectorConfigs(&config.AppParams{
+		Collectors: &empty,
+		CollectorConfigs: map[string]collector.Config{
+			"system": {Interval: 5},
+		},
+	}, names)
+	require.Equal(t, map[string]collector.Config{"system": {Interval: 5}}, configs)
+
+	configs = collectorConfigs(&config.AppParams{
+		Collectors: &list,
+		CollectorConfigs: map[string]collector.Config{
+			"system": {Enabled: &enabled, Interval: 5},
+		},
+	}, names)
+	require.Len(t, configs, 3)
+	require.True(t, *configs["memstat"].Enabled)
+	require.True(t, *configs["cpu"].Enabled)
+	require.False(t, *configs["system"].Enabled)
+	require.Equal(t, 5, configs["system"].Interval)
+
+	//unknown collector of list is rejected by registry
+	a := &Agent{}
+	require.ErrorContains(t, a.SetupCollectors(configs, time.Second), `unknown collector "cpu"`)
+}
@@ -0,0 +1,226 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+)
+
+// NameCgroup name of cgroup v2 collector
+const NameCgroup string = "cgroup"
+
+// CgroupOptions options of cgroup collector
+type CgroupOptions struct {
+	Path string `json:"path"` //cgroup directory, /sys/fs/cgroup is cgroup of container with own cgroup namespace
+}
+
+// Cgroup collects resource usage of cgroup v2 from its interface files, files of disabled controllers are skipped.
+// Usage and limits are gauges Cgroup<Stat>, throttling and io are counters with increments since previous poll
+type Cgroup struct {
+	Base
+	now      func() time.Time
+	lastCPU  *cpuSample //cpu usage in seconds at previous poll
+	counters *counters  //throttling and io counters
+	path     string
+}
+
+// NewCgroup is factory of cgroup collector
+func NewCgroup(base Base, options json.RawMessage) (Collector, error) {
+	opts := CgroupOptions{Path: cgroupRoot}
+	if err := DecodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	return &Cgroup{
+		Base:     base,
+		now:      time.Now,
+		counters: newCounters(),
+		path:     opts.Path,
+	}, nil
+}
+
+func (c *Cgroup) Collect(ctx context.Context) ([]storage.Metrics, error) {
+	defer c.counters.rotate()
+	var errs []error
+	//cpu.stat exists in every cgroup v2 directory, so error of cpu is reported even if file doesn't exist
+	metrics, err := c.cpu()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, read := range []func() ([]storage.Metrics, error){c.memory, c.io} {
+		m, err := read()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		metrics = append(metrics, m...)
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// memory returns memory usage and limit, limit isn't reported if memory is not limited
+func (c *Cgroup) memory() ([]storage.Metrics, error) {
+	current, err := c.readValue("memory.current")
+	if err != nil {
+		return nil, err
+	}
+	metrics := []storage.Metrics{gauge("CgroupMemoryUsage", float64(current))}
+	limit, err := c.readValue("memory.max")
+	if err != nil {
+		return metrics, err
+	}
+	if limit >= 0 {
+		metrics = append(metrics, gauge("CgroupMemoryLimit", float64(limit)))
+	}
+	return metrics, nil
+}
+
+// cpu returns cpu utilization in percent of one cpu, cpu limit in cpus and throttling counters
+func (c *Cgroup) cpu() ([]storage.Metrics, error) {
+	stat, err := c.readKeyValues("cpu.stat")
+	if err != nil {
+		return nil, err
+	}
+	var metrics []storage.Metrics
+	now := c.now()
+	usage := float64(stat["usage_usec"]) / 1e6
+	if c.lastCPU != nil && now.After(c.lastCPU.at) {
+		percent := max(usage-c.lastCPU.cpu, 0) / now.Sub(c.lastCPU.at).Seconds() * 100
+		metrics = append(metrics, gauge("CgroupCPUUtilization", percent))
+	}
+	c.lastCPU = &cpuSample{at: now, cpu: usage}
+	//throttling stat is reported by cpu controller only
+	for _, v := range []struct {
+		name string
+		key  string
+	}{
+		{"CgroupCPUPeriods", "nr_periods"},
+		{"CgroupCPUThrottledPeriods", "nr_throttled"},
+		{"CgroupCPUThrottledUsec", "throttled_usec"},
+	} {
+		value, ok := stat[v.key]
+		if !ok {
+			continue
+		}
+		if delta, ok := c.counters.delta(v.name, value); ok {
+			metrics = append(metrics, counter(v.name, delta))
+		}
+	}
+	limit, err := c.cpuLimit()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return metrics, err
+	}
+	if limit > 0 {
+		metrics = append(metrics, gauge("CgroupCPULimit", limit))
+	}
+	return metrics, nil
+}
+
+// cpuLimit returns number of cpus cgroup is limited by, 0 if cpu is not limited
+func (c *Cgroup) cpuLimit() (float64, error) {
+	b, err := os.ReadFile(filepath.Join(c.path, "cpu.max"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("cpu.max: invalid format %q", b)
+	}
+	if fields[0] == "max" {
+		return 0, nil
+	}
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("cpu.max: %w", err)
+	}
+	period, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("cpu.max: invalid period %q", fields[1])
+	}
+	return quota / period, nil
+}
+
+// io returns io counters of devices, io.stat has line "<major>:<minor> rbytes=.. wbytes=.. rios=.. wios=.." per device
+func (c *Cgroup) io() ([]storage.Metrics, error) {
+	b, err := os.ReadFile(filepath.Join(c.path, "io.stat"))
+	if err != nil {
+		return nil, err
+	}
+	var metrics []storage.Metrics
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		dev := label(fields[0])
+		stat := make(map[string]uint64, len(fields)-1)
+		for _, f := range fields[1:] {
+			key, value, ok := strings.Cut(f, "=")
+			if !ok {
+				continue
+			}
+			if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+				stat[key] = v
+			}
+		}
+		for _, v := range []struct {
+			name string
+			key  string
+		}{
+			{"CgroupIOReadBytes." + dev, "rbytes"},
+			{"CgroupIOWriteBytes." + dev, "wbytes"},
+			{"CgroupIOReadCount." + dev, "rios"},
+			{"CgroupIOWriteCount." + dev, "wios"},
+		} {
+			if delta, ok := c.counters.delta(v.name, stat[v.key]); ok {
+				metrics = append(metrics, counter(v.name, delta))
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// readValue reads file with single number, -1 is returned for "max"
+func (c *Cgroup) readValue(name string) (int64, error) {
+	b, err := os.ReadFile(filepath.Join(c.path, name))
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return -1, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+// readKeyValues reads file with "<key> <value>" lines
+func (c *Cgroup) readKeyValues(name string) (map[string]uint64, error) {
+	b, err := os.ReadFile(filepath.Join(c.path, name))
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		values[key] = v
+	}
+	return values, nil
+}
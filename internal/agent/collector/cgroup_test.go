@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCgroup(t *testing.T, path string) *Cgroup {
+	options, err := json.Marshal(CgroupOptions{Path: path})
+	require.NoError(t, err)
+	c, err := NewCgroup(NewBase(NameCgroup, time.Second), options)
+	require.NoError(t, err)
+	return c.(*Cgroup)
+}
+
+func TestCgroup_Collect(t *testing.T) {
+	c := newTestCgroup(t, "testdata/cgroup/limited")
+	clock := time.Now()
+	c.now = func() time.Time { return clock }
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []storage.Metrics{
+		gauge("CgroupCPULimit", 0.5),
+		gauge("CgroupMemoryUsage", 104857600),
+		gauge("CgroupMemoryLimit", 268435456),
+	}, metrics)
+
+	//utilization and counters are reported from second poll
+	c.path = "testdata/cgroup/limited-next"
+	clock = clock.Add(2 * time.Second)
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []storage.Metrics{
+		gauge("CgroupCPUUtilization", 50),
+		counter("CgroupCPUPeriods", 20),
+		counter("CgroupCPUThrottledPeriods", 5),
+		counter("CgroupCPUThrottledUsec", 100000),
+		gauge("CgroupCPULimit", 0.5),
+		gauge("CgroupMemoryUsage", 115343360),
+		gauge("CgroupMemoryLimit", 268435456),
+		counter("CgroupIOReadBytes.8:0", 4096),
+		counter("CgroupIOWriteBytes.8:0", 0),
+		counter("CgroupIOReadCount.8:0", 1),
+		counter("CgroupIOWriteCount.8:0", 0),
+	}, metrics)
+}
+
+func TestCgroup_CollectUnlimited(t *testing.T) {
+	c := newTestCgroup(t, "testdata/cgroup/unlimited")
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	//limits and files of disabled controllers are not reported
+	require.Equal(t, []storage.Metrics{gauge("CgroupMemoryUsage", 52428800)}, metrics)
+}
+
+func TestCgroup_CollectNotCgroup(t *testing.T) {
+	c := newTestCgroup(t, t.TempDir())
+	_, err := c.Collect(context.Background())
+	require.ErrorContains(t, err, "cpu.stat")
+}
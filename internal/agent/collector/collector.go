@@ -0,0 +1,127 @@
+// Package collector implements sources of agent metrics, collectors are registered in Registry
+// and configured individually by agent config
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/logger"
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Collector source of metrics polled by agent
+type Collector interface {
+	Name() string                                           //name of collector in registry
+	Interval() time.Duration                                //interval of collecting
+	Collect(ctx context.Context) ([]storage.Metrics, error) //returns current metrics
+}
+
+// Base has name and interval of collector, it is embedded by collector implementations
+type Base struct {
+	name     string
+	interval time.Duration
+}
+
+// NewBase is factory method
+func NewBase(name string, interval time.Duration) Base {
+	return Base{name: name, interval: interval}
+}
+
+func (b Base) Name() string {
+	return b.name
+}
+
+func (b Base) Interval() time.Duration {
+	return b.interval
+}
+
+// Config of collector in agent config
+type Config struct {
+	Enabled  *bool           `json:"enabled"`  //collector is running, default of registry is used if not set
+	Interval int             `json:"interval"` //collect interval in seconds, poll interval is used if 0
+	Options  json.RawMessage `json:"options"`  //options specific for collector
+}
+
+// DecodeOptions decodes collector options into v, fields of v are left as is if options are not set
+func DecodeOptions(options json.RawMessage, v any) error {
+	if len(options) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(options))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// Run collects metrics every collector interval until ctx is done, returns channel for reading them
+func Run(ctx context.Context, c Collector) chan storage.Metrics {
+	ch := make(chan storage.Metrics, 20)
+	ticker := time.NewTicker(c.Interval())
+	go func() {
+		defer close(ch)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				metrics, err := c.Collect(ctx)
+				if err != nil {
+					//metrics collected before error are sent anyway
+					logger.Log.Error("collect metrics", zap.String("collector", c.Name()), zap.Error(err))
+				}
+				for _, m := range metrics {
+					ch <- m
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// gauge returns gauge metric
+func gauge(name string, value float64) storage.Metrics {
+	return storage.Metrics{
+		ID:          name,
+		MType:       string(storage.MetricTypeGauge),
+		ActualValue: value,
+	}
+}
+
+// counter returns counter metric
+func counter(name string, delta int64) storage.Metrics {
+	return storage.Metrics{
+		ID:          name,
+		MType:       string(storage.MetricTypeCounter),
+		ActualValue: delta,
+	}
+}
+
+// counters remembers values of monotonic counters between polls to report their increments
+type counters struct {
+	last map[string]uint64 //values of previous poll
+	cur  map[string]uint64 //values of current poll
+}
+
+func newCounters() *counters {
+	return &counters{last: make(map[string]uint64), cur: make(map[string]uint64)}
+}
+
+// delta returns increment of counter since previous poll, false on first poll of counter and after its reset
+func (c *counters) delta(name string, v uint64) (int64, bool) {
+	c.cur[name] = v
+	prev, ok := c.last[name]
+	if !ok || v < prev {
+		return 0, false
+	}
+	return int64(v - prev), true
+}
+
+// rotate finishes poll, counters missing in poll are forgotten
+func (c *counters) rotate() {
+	c.last = c.cur
+	c.cur = make(map[string]uint64, len(c.last))
+}
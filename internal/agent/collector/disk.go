@@ -0,0 +1,120 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/shirou/gopsutil/disk"
+)
+
+// NameDisk name of disk usage and io collector
+const NameDisk string = "disk"
+
+// DiskOptions options of disk collector
+type DiskOptions struct {
+	Mountpoints Filter `json:"mountpoints"` //glob patterns of reported mountpoints
+	Fstypes     Filter `json:"fstypes"`     //glob patterns of reported filesystem types
+	All         bool   `json:"all"`         //report pseudo filesystems too, only physical devices are reported by default
+}
+
+// Disk collects usage of mounted filesystems and io of their block devices.
+// Usage metrics are gauges Disk<Stat>.<mountpoint>, io metrics are counters Disk<Stat>.<device> with increments since previous poll
+type Disk struct {
+	Base
+	partitionsFunc func(ctx context.Context, all bool) ([]disk.PartitionStat, error)
+	usageFunc      func(ctx context.Context, path string) (*disk.UsageStat, error)
+	ioFunc         func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error)
+	io             *counters //io counters of devices
+	opts           DiskOptions
+}
+
+// NewDisk is factory of disk collector
+func NewDisk(base Base, options json.RawMessage) (Collector, error) {
+	c := &Disk{
+		Base:           base,
+		partitionsFunc: disk.PartitionsWithContext,
+		usageFunc:      disk.UsageWithContext,
+		ioFunc:         disk.IOCountersWithContext,
+		io:             newCounters(),
+	}
+	if err := DecodeOptions(options, &c.opts); err != nil {
+		return nil, err
+	}
+	if err := errors.Join(c.opts.Mountpoints.Validate(), c.opts.Fstypes.Validate()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Disk) Collect(ctx context.Context) ([]storage.Metrics, error) {
+	partitions, err := c.partitionsFunc(ctx, c.opts.All)
+	if err != nil {
+		return nil, fmt.Errorf("read partitions: %w", err)
+	}
+	var metrics []storage.Metrics
+	var errs []error
+	var devices []string
+	for _, p := range partitions {
+		if !c.opts.Mountpoints.Match(p.Mountpoint) || !c.opts.Fstypes.Match(p.Fstype) {
+			continue
+		}
+		usage, err := c.usageFunc(ctx, p.Mountpoint)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("read usage of %s: %w", p.Mountpoint, err))
+			continue
+		}
+		mount := label(p.Mountpoint)
+		metrics = append(metrics,
+			gauge("DiskTotal."+mount, float64(usage.Total)),
+			gauge("DiskUsed."+mount, float64(usage.Used)),
+			gauge("DiskFree."+mount, float64(usage.Free)),
+			gauge("DiskInodesTotal."+mount, float64(usage.InodesTotal)),
+			gauge("DiskInodesUsed."+mount, float64(usage.InodesUsed)),
+			gauge("DiskInodesFree."+mount, float64(usage.InodesFree)),
+		)
+		//pseudo filesystems don't have block device
+		if strings.HasPrefix(p.Device, "/dev/") {
+			devices = append(devices, filepath.Base(p.Device))
+		}
+	}
+	if len(devices) > 0 {
+		io, err := c.ioFunc(ctx, devices...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("read io: %w", err))
+		} else {
+			metrics = append(metrics, c.ioMetrics(io)...)
+		}
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// ioMetrics returns increments of io counters of devices since previous poll
+func (c *Disk) ioMetrics(io map[string]disk.IOCountersStat) []storage.Metrics {
+	defer c.io.rotate()
+	var metrics []storage.Metrics
+	for _, name := range slices.Sorted(maps.Keys(io)) {
+		dev := label(name)
+		stat := io[name]
+		for _, v := range []struct {
+			name  string
+			value uint64
+		}{
+			{"DiskReadBytes." + dev, stat.ReadBytes},
+			{"DiskWriteBytes." + dev, stat.WriteBytes},
+			{"DiskReadCount." + dev, stat.ReadCount},
+			{"DiskWriteCount." + dev, stat.WriteCount},
+		} {
+			if delta, ok := c.io.delta(v.name, v.value); ok {
+				metrics = append(metrics, counter(v.name, delta))
+			}
+		}
+	}
+	return metrics
+}
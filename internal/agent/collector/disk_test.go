@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisk_Collect(t *testing.T) {
+	c, err := NewDisk(NewBase(NameDisk, time.Second), json.RawMessage(`{
+		"mountpoints": {"exclude": ["/boot", "/boot/*"]},
+		"fstypes": {"include": ["ext4", "xfs"]}
+	}`))
+	require.NoError(t, err)
+	d := c.(*Disk)
+	d.partitionsFunc = func(ctx context.Context, all bool) ([]disk.PartitionStat, error) {
+		return []disk.PartitionStat{
+			{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4"},
+			{Device: "/dev/sda2", Mountpoint: "/boot/efi", Fstype: "ext4"},
+			{Device: "/dev/sdb1", Mountpoint: "/var/lib/data", Fstype: "xfs"},
+			{Device: "tmpfs", Mountpoint: "/tmp", Fstype: "tmpfs"},
+		}, nil
+	}
+	d.usageFunc = func(ctx context.Context, path string) (*disk.UsageStat, error) {
+		return &disk.UsageStat{Total: 100, Used: 60, Free: 40, InodesTotal: 10, InodesUsed: 3, InodesFree: 7}, nil
+	}
+	var readBytes uint64 = 1000
+	d.ioFunc = func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error) {
+		require.Equal(t, []string{"sda1", "sdb1"}, names)
+		readBytes += 500
+		return map[string]disk.IOCountersStat{
+			"sda1": {ReadBytes: readBytes, WriteBytes: 10, ReadCount: 1, WriteCount: 1},
+		}, nil
+	}
+	usage := func(mount string) []storage.Metrics {
+		return []storage.Metrics{
+			gauge("DiskTotal."+mount, 100),
+			gauge("DiskUsed."+mount, 60),
+			gauge("DiskFree."+mount, 40),
+			gauge("DiskInodesTotal."+mount, 10),
+			gauge("DiskInodesUsed."+mount, 3),
+			gauge("DiskInodesFree."+mount, 7),
+		}
+	}
+
+	//io counters are reported from second poll
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, append(usage("_"), usage("_var_lib_data")...), metrics)
+
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, append(append(usage("_"), usage("_var_lib_data")...),
+		counter("DiskReadBytes.sda1", 500),
+		counter("DiskWriteBytes.sda1", 0),
+		counter("DiskReadCount.sda1", 0),
+		counter("DiskWriteCount.sda1", 0),
+	), metrics)
+}
+
+func TestNewDisk(t *testing.T) {
+	_, err := NewDisk(NewBase(NameDisk, time.Second), json.RawMessage(`{"mountpoints": {"include": ["["]}}`))
+	require.ErrorContains(t, err, `pattern "["`)
+	_, err = NewDisk(NewBase(NameDisk, time.Second), json.RawMessage(`{"devices": {}}`))
+	require.Error(t, err)
+}
+
+func TestFilter_Match(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty filter", value: "/", want: true},
+		{name: "included", filter: Filter{Include: []string{"/data*"}}, value: "/data1", want: true},
+		{name: "not included", filter: Filter{Include: []string{"/data*"}}, value: "/", want: false},
+		{name: "excluded", filter: Filter{Include: []string{"eth*"}, Exclude: []string{"eth1"}}, value: "eth1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.filter.Match(tt.value))
+		})
+	}
+}
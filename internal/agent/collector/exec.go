@@ -0,0 +1,208 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+)
+
+// NameExec name of collector running external commands
+const NameExec string = "exec"
+
+// defaultExecTimeout timeout of command if it is not set in options
+const defaultExecTimeout time.Duration = 10 * time.Second
+
+const (
+	ExecFormatText string = "text" //lines "<name> <type> <value>"
+	ExecFormatJSON string = "json" //array of metrics in format of batch update
+)
+
+// ExecCommand command printing metrics to stdout
+type ExecCommand struct {
+	Name    string   `json:"name"`    //name of command in errors
+	Command []string `json:"command"` //program and its arguments, command isn't run by shell
+	Timeout int      `json:"timeout"` //timeout in seconds, 10 seconds by default
+	Format  string   `json:"format"`  //format of output, text or json, text by default
+}
+
+// ExecOptions options of exec collector
+type ExecOptions struct {
+	Commands []ExecCommand `json:"commands"`
+}
+
+// Exec runs commands every poll and reports metrics they print. Commands are run concurrently,
+// command is killed if it doesn't finish in timeout. Invalid lines of output are reported as errors
+// and other metrics of command are reported anyway
+type Exec struct {
+	Base
+	validator *storage.NameValidator
+	opts      ExecOptions
+}
+
+// NewExec is factory of exec collector
+func NewExec(base Base, options json.RawMessage) (Collector, error) {
+	c := &Exec{
+		Base:      base,
+		validator: storage.NewNameValidator(),
+	}
+	if err := DecodeOptions(options, &c.opts); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i := range c.opts.Commands {
+		cmd := &c.opts.Commands[i]
+		if len(cmd.Command) == 0 {
+			return nil, errors.New("command is not set")
+		}
+		if cmd.Name == "" {
+			cmd.Name = cmd.Command[0]
+		}
+		if names[cmd.Name] {
+			return nil, fmt.Errorf("command %q is set twice", cmd.Name)
+		}
+		names[cmd.Name] = true
+		if cmd.Timeout < 0 {
+			return nil, fmt.Errorf("command %q: timeout must not be negative", cmd.Name)
+		}
+		switch cmd.Format {
+		case "":
+			cmd.Format = ExecFormatText
+		case ExecFormatText, ExecFormatJSON:
+		default:
+			return nil, fmt.Errorf("command %q: unknown format %q", cmd.Name, cmd.Format)
+		}
+	}
+	return c, nil
+}
+
+func (c *Exec) Collect(ctx context.Context) ([]storage.Metrics, error) {
+	metrics := make([][]storage.Metrics, len(c.opts.Commands))
+	errs := make([]error, len(c.opts.Commands))
+	var wg sync.WaitGroup
+	for i, cmd := range c.opts.Commands {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			metrics[i], errs[i] = c.run(ctx, cmd)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("command %q: %w", cmd.Name, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	var result []storage.Metrics
+	for _, m := range metrics {
+		result = append(result, m...)
+	}
+	return result, errors.Join(errs...)
+}
+
+// run runs command and parses its output
+func (c *Exec) run(ctx context.Context, command ExecCommand) ([]storage.Metrics, error) {
+	timeout := defaultExecTimeout
+	if command.Timeout > 0 {
+		timeout = time.Duration(command.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command.Command[0], command.Command[1:]...)
+	//children of killed command may keep stdout open, it isn't waited for after timeout
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("timeout %s exceeded", timeout)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(exitErr.Stderr))
+		}
+		return nil, err
+	}
+	if command.Format == ExecFormatJSON {
+		return c.parseJSON(out)
+	}
+	return c.parseText(out)
+}
+
+// parseText parses lines "<name> <type> <value>", empty lines and lines started with # are skipped
+func (c *Exec) parseText(out []byte) ([]storage.Metrics, error) {
+	var metrics []storage.Metrics
+	var errs []error
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			errs = append(errs, fmt.Errorf("line %d: expected <name> <type> <value>", n))
+			continue
+		}
+		m, err := c.metric(fields[0], fields[1], fields[2])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", n, err))
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// parseJSON parses array of metrics, values of gauges are in "value" and increments of counters are in "delta"
+func (c *Exec) parseJSON(out []byte) ([]storage.Metrics, error) {
+	var parsed []storage.Metrics
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return nil, fmt.Errorf("parse json: %w", err)
+	}
+	var metrics []storage.Metrics
+	var errs []error
+	for i, m := range parsed {
+		if m.ActualValue == nil {
+			errs = append(errs, fmt.Errorf("metric %d: value is not set", i))
+			continue
+		}
+		if err := c.validator.Validate(storage.MetricName(m.ID)); err != nil {
+			errs = append(errs, fmt.Errorf("metric %d: %w", i, err))
+			continue
+		}
+		metrics = append(metrics, storage.Metrics{ID: m.ID, MType: m.MType, ActualValue: m.ActualValue})
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// metric returns metric of text output
+func (c *Exec) metric(name, mtype, value string) (storage.Metrics, error) {
+	if err := c.validator.Validate(storage.MetricName(name)); err != nil {
+		return storage.Metrics{}, err
+	}
+	switch storage.MetricType(mtype) {
+	case storage.MetricTypeGauge:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return storage.Metrics{}, fmt.Errorf("invalid gauge value %q", value)
+		}
+		return gauge(name, v), nil
+	case storage.MetricTypeCounter:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return storage.Metrics{}, fmt.Errorf("invalid counter value %q", value)
+		}
+		return counter(name, v), nil
+	}
+	return storage.Metrics{}, fmt.Errorf("unknown metric type %q", mtype)
+}
@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExec_Collect(t *testing.T) {
+	c, err := NewExec(NewBase(NameExec, time.Second), json.RawMessage(`{"commands": [
+		{"name": "text", "command": ["sh", "-c", "echo '# queue stat'; echo 'QueueSize gauge 12.5'; echo; echo 'QueueJobs counter 3'; echo 'QueueBad counter 1.5'"]},
+		{"name": "json", "format": "json", "command": ["echo", "[{\"id\": \"Orders\", \"type\": \"counter\", \"delta\": 7}, {\"id\": \"Latency\", \"type\": \"gauge\", \"value\": 0.2}]"]}
+	]}`))
+	require.NoError(t, err)
+	metrics, err := c.Collect(context.Background())
+	require.ErrorContains(t, err, `command "text": line 5: invalid counter value "1.5"`)
+	require.Equal(t, []storage.Metrics{
+		gauge("QueueSize", 12.5),
+		counter("QueueJobs", 3),
+		counter("Orders", 7),
+		gauge("Latency", 0.2),
+	}, metrics)
+}
+
+func TestExec_CollectFailed(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		wantErr string
+	}{
+		{
+			name:    "exit code",
+			command: `["sh", "-c", "echo failed >&2; exit 2"]`,
+			wantErr: "exit status 2: failed",
+		},
+		{
+			name:    "timeout",
+			command: `["sleep", "5"]`,
+			wantErr: "timeout 1s exceeded",
+		},
+		{
+			name:    "not found",
+			command: `["/not/exists"]`,
+			wantErr: "no such file or directory",
+		},
+		{
+			name:    "invalid line",
+			command: `["echo", "Queue Size gauge 1"]`,
+			wantErr: "line 1: expected <name> <type> <value>",
+		},
+		{
+			name:    "invalid name",
+			command: `["echo", "Queue/Size gauge 1"]`,
+			wantErr: "metric name is invalid",
+		},
+		{
+			name:    "unknown type",
+			command: `["echo", "QueueSize histogram 1"]`,
+			wantErr: `unknown metric type "histogram"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewExec(NewBase(NameExec, time.Second), json.RawMessage(`{"commands": [{"name": "cmd", "timeout": 1, "command": `+tt.command+`}]}`))
+			require.NoError(t, err)
+			metrics, err := c.Collect(context.Background())
+			require.ErrorContains(t, err, tt.wantErr)
+			require.Empty(t, metrics)
+		})
+	}
+}
+
+func TestNewExec(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		wantErr string
+	}{
+		{
+			name:    "command is not set",
+			options: `{"commands": [{"name": "cmd"}]}`,
+			wantErr: "command is not set",
+		},
+		{
+			name:    "set twice",
+			options: `{"commands": [{"command": ["date"]}, {"command": ["date", "-u"]}]}`,
+			wantErr: `command "date" is set twice`,
+		},
+		{
+			name:    "unknown format",
+			options: `{"commands": [{"command": ["date"], "format": "xml"}]}`,
+			wantErr: `unknown format "xml"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewExec(NewBase(NameExec, time.Second), json.RawMessage(tt.options))
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter glob patterns of included and excluded values, all values are included if include patterns are not set
+type Filter struct {
+	Include []string `json:"include"` //values matching any pattern are included
+	Exclude []string `json:"exclude"` //values matching any pattern are excluded, even if they are included
+}
+
+// Validate checks syntax of patterns
+func (f Filter) Validate() error {
+	for _, p := range append(f.Include, f.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Match returns true if value is included by filter
+func (f Filter) Match(v string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, v) {
+		return false
+	}
+	return !matchAny(f.Exclude, v)
+}
+
+func matchAny(patterns []string, v string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// label converts mountpoint, device or interface name to part of metric name, different values get different labels:
+// "/" is "_", "_" is "-_", "-" is "--" and other characters not allowed in metric names are "-" with hex code,
+// e.g. "/" is "_", "/var/lib" is "_var_lib" and "/var_lib" is "_var-_lib"
+func label(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == ':':
+			b.WriteByte(c)
+		case c == '/':
+			b.WriteByte('_')
+		case c == '_', c == '-':
+			b.WriteByte('-')
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "-%02x", c)
+		}
+	}
+	return b.String()
+}
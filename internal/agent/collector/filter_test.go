@@ -0,0 +1,31 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		v    string
+		want string
+	}{
+		{v: "/", want: "_"},
+		{v: "/root", want: "_root"},
+		{v: "/var/lib", want: "_var_lib"},
+		{v: "/var_lib", want: "_var-_lib"},
+		{v: "br-lan", want: "br--lan"},
+		{v: "/mnt/my disk", want: "_mnt_my-20disk"},
+		{v: "eth0.100", want: "eth0.100"},
+	}
+	labels := make(map[string]string)
+	for _, tt := range tests {
+		got := label(tt.v)
+		require.Equal(t, tt.want, got, tt.v)
+		require.NotContains(t, labels, got, "label of %q collides with %q", tt.v, labels[got])
+		labels[got] = tt.v
+	}
+	//labels of values with "_" and "/" next to each other don't collide
+	require.NotEqual(t, label("a_/b"), label("a/_b"))
+}
@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/process"
+)
+
+// NameHost name of load average, uptime and processes collector
+const NameHost string = "host"
+
+// Host collects load averages, uptime in seconds, number of processes and threads as gauges,
+// number of context switches and created processes as counters with increments since previous poll
+type Host struct {
+	Base
+	loadFunc   func(ctx context.Context) (*load.AvgStat, error)
+	miscFunc   func(ctx context.Context) (*load.MiscStat, error)
+	uptimeFunc func(ctx context.Context) (uint64, error)
+	pidsFunc   func(ctx context.Context) ([]int32, error)
+	totals     *counters //context switches and created processes
+}
+
+// NewHost is factory of host collector
+func NewHost(base Base, options json.RawMessage) (Collector, error) {
+	if err := DecodeOptions(options, &struct{}{}); err != nil {
+		return nil, err
+	}
+	return &Host{
+		Base:       base,
+		loadFunc:   load.AvgWithContext,
+		miscFunc:   load.MiscWithContext,
+		uptimeFunc: host.UptimeWithContext,
+		pidsFunc:   process.PidsWithContext,
+		totals:     newCounters(),
+	}, nil
+}
+
+func (c *Host) Collect(ctx context.Context) ([]storage.Metrics, error) {
+	var metrics []storage.Metrics
+	var errs []error
+	if avg, err := c.loadFunc(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("read load average: %w", err))
+	} else {
+		metrics = append(metrics,
+			gauge("Load1", avg.Load1),
+			gauge("Load5", avg.Load5),
+			gauge("Load15", avg.Load15),
+		)
+	}
+	if uptime, err := c.uptimeFunc(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("read uptime: %w", err))
+	} else {
+		metrics = append(metrics, gauge("Uptime", float64(uptime)))
+	}
+	if pids, err := c.pidsFunc(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("read processes: %w", err))
+	} else {
+		metrics = append(metrics, gauge("Processes", float64(len(pids))))
+	}
+	if misc, err := c.miscFunc(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("read kernel stat: %w", err))
+	} else {
+		metrics = append(metrics,
+			//total of kernel scheduling entities is number of threads
+			gauge("Threads", float64(misc.ProcsTotal)),
+			gauge("ProcessesRunning", float64(misc.ProcsRunning)),
+			gauge("ProcessesBlocked", float64(misc.ProcsBlocked)),
+		)
+		for _, v := range []struct {
+			name  string
+			value int
+		}{
+			{"ContextSwitches", misc.Ctxt},
+			{"ProcessesCreated", misc.ProcsCreated},
+		} {
+			if delta, ok := c.totals.delta(v.name, uint64(v.value)); ok {
+				metrics = append(metrics, counter(v.name, delta))
+			}
+		}
+		c.totals.rotate()
+	}
+	return metrics, errors.Join(errs...)
+}
@@ -0,0 +1,65 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/shirou/gopsutil/load"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHost_Collect(t *testing.T) {
+	c, err := NewHost(NewBase(NameHost, time.Second), nil)
+	require.NoError(t, err)
+	h := c.(*Host)
+	h.loadFunc = func(ctx context.Context) (*load.AvgStat, error) {
+		return &load.AvgStat{Load1: 1.5, Load5: 1, Load15: 0.5}, nil
+	}
+	h.uptimeFunc = func(ctx context.Context) (uint64, error) {
+		return 3600, nil
+	}
+	h.pidsFunc = func(ctx context.Context) ([]int32, error) {
+		return []int32{1, 2, 3}, nil
+	}
+	ctxt := 1000
+	h.miscFunc = func(ctx context.Context) (*load.MiscStat, error) {
+		ctxt += 250
+		return &load.MiscStat{ProcsTotal: 10, ProcsRunning: 2, ProcsBlocked: 1, Ctxt: ctxt, ProcsCreated: 50}, nil
+	}
+	want := []storage.Metrics{
+		gauge("Load1", 1.5),
+		gauge("Load5", 1),
+		gauge("Load15", 0.5),
+		gauge("Uptime", 3600),
+		gauge("Processes", 3),
+		gauge("Threads", 10),
+		gauge("ProcessesRunning", 2),
+		gauge("ProcessesBlocked", 1),
+	}
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, want, metrics)
+	//counters are reported from second poll
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, append(want, counter("ContextSwitches", 250), counter("ProcessesCreated", 0)), metrics)
+
+	//other stats are reported if one can't be read
+	h.uptimeFunc = func(ctx context.Context) (uint64, error) {
+		return 0, errors.New("no uptime")
+	}
+	metrics, err = c.Collect(context.Background())
+	require.ErrorContains(t, err, "no uptime")
+	require.NotContains(t, metrics, gauge("Uptime", 3600))
+	require.Contains(t, metrics, gauge("Load1", 1.5))
+}
+
+func TestNewHost(t *testing.T) {
+	_, err := NewHost(NewBase(NameHost, time.Second), json.RawMessage(`{"interfaces": {}}`))
+	require.Error(t, err)
+}
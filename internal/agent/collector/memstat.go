@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+)
+
+// NameMemStat name of runtime memory stat collector
+const NameMemStat string = "memstat"
+
+// MemStat collects runtime memory stat, PollCount and RandomValue
+type MemStat struct {
+	Base
+	readFunc func(m *runtime.MemStats) //reads memory stat
+	rand     *rand.Rand
+}
+
+// NewMemStat is factory method
+func NewMemStat(base Base, readFunc func(m *runtime.MemStats)) *MemStat {
+	return &MemStat{
+		Base:     base,
+		readFunc: readFunc,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (c *MemStat) Collect(ctx context.Context) ([]storage.Metrics, error) {
+	var stats runtime.MemStats
+	c.readFunc(&stats)
+	r := reflect.ValueOf(stats)
+	var metrics []storage.Metrics
+	for _, metricName := range storage.GetGaugeMetrics() {
+		rv := r.FieldByName(string(metricName))
+		if !rv.IsValid() {
+			continue
+		}
+		var v float64
+		if rv.CanUint() {
+			v = float64(rv.Uint())
+		} else if rv.CanFloat() {
+			v = rv.Float()
+		}
+		metrics = append(metrics, gauge(string(metricName), v))
+	}
+	metrics = append(metrics,
+		counter(string(storage.MetricNamePollCount), 1),
+		gauge(string(storage.MetricNameRandomValue), c.rand.Float64()),
+	)
+	return metrics, nil
+}
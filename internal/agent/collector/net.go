@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/shirou/gopsutil/net"
+)
+
+// NameNet name of network interfaces and tcp connections collector
+const NameNet string = "net"
+
+// tcpStates reported states of tcp connections, states without connections are reported as 0
+var tcpStates = []string{
+	"ESTABLISHED",
+	"SYN_SENT",
+	"SYN_RECV",
+	"FIN_WAIT1",
+	"FIN_WAIT2",
+	"TIME_WAIT",
+	"CLOSE",
+	"CLOSE_WAIT",
+	"LAST_ACK",
+	"LISTEN",
+	"CLOSING",
+}
+
+// NetOptions options of network collector
+type NetOptions struct {
+	Interfaces Filter `json:"interfaces"` //glob patterns of reported interfaces
+	TCP        *bool  `json:"tcp"`        //report number of tcp connections by state, true if not set
+}
+
+// Net collects io of network interfaces and number of tcp connections by state.
+// Interface metrics are counters Net<Stat>.<interface> with increments since previous poll, tcp metrics are gauges TCP<STATE>
+type Net struct {
+	Base
+	ioFunc    func(ctx context.Context, pernic bool) ([]net.IOCountersStat, error)
+	connsFunc func(ctx context.Context, kind string) ([]net.ConnectionStat, error)
+	io        *counters //io counters of interfaces
+	opts      NetOptions
+}
+
+// NewNet is factory of network collector
+func NewNet(base Base, options json.RawMessage) (Collector, error) {
+	c := &Net{
+		Base:      base,
+		ioFunc:    net.IOCountersWithContext,
+		connsFunc: net.ConnectionsWithoutUidsWithContext,
+		io:        newCounters(),
+	}
+	if err := DecodeOptions(options, &c.opts); err != nil {
+		return nil, err
+	}
+	if err := c.opts.Interfaces.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Net) Collect(ctx context.Context) ([]storage.Metrics, error) {
+	var metrics []storage.Metrics
+	var errs []error
+	if io, err := c.ioFunc(ctx, true); err != nil {
+		errs = append(errs, fmt.Errorf("read interfaces io: %w", err))
+	} else {
+		metrics = append(metrics, c.ioMetrics(io)...)
+	}
+	if c.opts.TCP == nil || *c.opts.TCP {
+		conns, err := c.connsFunc(ctx, "tcp")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("read tcp connections: %w", err))
+		} else {
+			metrics = append(metrics, tcpMetrics(conns)...)
+		}
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// ioMetrics returns increments of io counters of interfaces since previous poll
+func (c *Net) ioMetrics(io []net.IOCountersStat) []storage.Metrics {
+	defer c.io.rotate()
+	var metrics []storage.Metrics
+	for _, stat := range io {
+		if !c.opts.Interfaces.Match(stat.Name) {
+			continue
+		}
+		iface := label(stat.Name)
+		for _, v := range []struct {
+			name  string
+			value uint64
+		}{
+			{"NetBytesRecv." + iface, stat.BytesRecv},
+			{"NetBytesSent." + iface, stat.BytesSent},
+			{"NetPacketsRecv." + iface, stat.PacketsRecv},
+			{"NetPacketsSent." + iface, stat.PacketsSent},
+			{"NetErrorsIn." + iface, stat.Errin},
+			{"NetErrorsOut." + iface, stat.Errout},
+			{"NetDropsIn." + iface, stat.Dropin},
+			{"NetDropsOut." + iface, stat.Dropout},
+		} {
+			if delta, ok := c.io.delta(v.name, v.value); ok {
+				metrics = append(metrics, counter(v.name, delta))
+			}
+		}
+	}
+	return metrics
+}
+
+// tcpMetrics returns number of connections in every tcp state
+func tcpMetrics(conns []net.ConnectionStat) []storage.Metrics {
+	states := make(map[string]int, len(tcpStates))
+	for _, conn := range conns {
+		states[conn.Status]++
+	}
+	metrics := make([]storage.Metrics, 0, len(tcpStates))
+	for _, state := range tcpStates {
+		metrics = append(metrics, gauge("TCP"+state, float64(states[state])))
+	}
+	return metrics
+}
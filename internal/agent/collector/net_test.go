@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/shirou/gopsutil/net"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNet_Collect(t *testing.T) {
+	c, err := NewNet(NewBase(NameNet, time.Second), json.RawMessage(`{"interfaces": {"exclude": ["lo"]}}`))
+	require.NoError(t, err)
+	n := c.(*Net)
+	var recv uint64 = 100
+	n.ioFunc = func(ctx context.Context, pernic bool) ([]net.IOCountersStat, error) {
+		require.True(t, pernic)
+		recv += 50
+		return []net.IOCountersStat{
+			{Name: "lo", BytesRecv: recv},
+			{Name: "eth0", BytesRecv: recv, BytesSent: 10, PacketsRecv: 3, Dropin: 1},
+		}, nil
+	}
+	n.connsFunc = func(ctx context.Context, kind string) ([]net.ConnectionStat, error) {
+		return []net.ConnectionStat{
+			{Status: "ESTABLISHED"},
+			{Status: "ESTABLISHED"},
+			{Status: "LISTEN"},
+		}, nil
+	}
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	//interfaces counters are reported from second poll
+	require.Len(t, metrics, len(tcpStates))
+	require.Contains(t, metrics, gauge("TCPESTABLISHED", 2))
+	require.Contains(t, metrics, gauge("TCPLISTEN", 1))
+	require.Contains(t, metrics, gauge("TCPTIME_WAIT", 0))
+
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 8+len(tcpStates))
+	require.Equal(t, []storage.Metrics{
+		counter("NetBytesRecv.eth0", 50),
+		counter("NetBytesSent.eth0", 0),
+	}, metrics[:2])
+	for _, m := range metrics {
+		require.NotContains(t, m.ID, ".lo")
+	}
+}
+
+func TestNet_CollectTCPDisabled(t *testing.T) {
+	c, err := NewNet(NewBase(NameNet, time.Second), json.RawMessage(`{"tcp": false}`))
+	require.NoError(t, err)
+	n := c.(*Net)
+	n.ioFunc = func(ctx context.Context, pernic bool) ([]net.IOCountersStat, error) {
+		return nil, errors.New("no proc")
+	}
+	n.connsFunc = func(ctx context.Context, kind string) ([]net.ConnectionStat, error) {
+		t.Fatal("tcp connections are read")
+		return nil, nil
+	}
+	metrics, err := c.Collect(context.Background())
+	require.ErrorContains(t, err, "no proc")
+	require.Empty(t, metrics)
+}
@@ -0,0 +1,281 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/shirou/gopsutil/process"
+)
+
+// NameProcess name of per-process collector
+const NameProcess string = "process"
+
+// cgroupRoot mountpoint of cgroup v2 hierarchy, relative cgroup paths are resolved from it
+const cgroupRoot string = "/sys/fs/cgroup"
+
+// ProcessWatch watched processes, they are selected by one of pattern, pid file or cgroup
+type ProcessWatch struct {
+	Name    string `json:"name"`     //label of processes in metric names
+	Pattern string `json:"pattern"`  //regexp of process name
+	PIDFile string `json:"pid_file"` //file with pid of process
+	Cgroup  string `json:"cgroup"`   //cgroup directory, absolute or relative to /sys/fs/cgroup
+	re      *regexp.Regexp
+}
+
+// ProcessOptions options of per-process collector
+type ProcessOptions struct {
+	Processes []ProcessWatch `json:"processes"`
+}
+
+// procStat stat of process, io and fds are nil if they are not readable
+type procStat struct {
+	io      *process.IOCountersStat
+	fds     *int32
+	cpu     float64 //user and system cpu time in seconds
+	rss     uint64
+	threads int32
+}
+
+// cpuSample cpu time of process at poll time
+type cpuSample struct {
+	at  time.Time
+	cpu float64
+}
+
+// Process collects stat of watched processes, stat of processes matched by watch is summed.
+// Metrics are gauges Process<Stat>.<name> and counters of io Process<Stat>.<name> with increments since previous poll
+type Process struct {
+	Base
+	pidsFunc func(ctx context.Context) ([]int32, error)
+	nameFunc func(ctx context.Context, pid int32) (string, error)
+	statFunc func(ctx context.Context, pid int32) (procStat, error)
+	now      func() time.Time
+	cpu      map[string]cpuSample //cpu time of processes by watch and pid at previous poll
+	io       *counters            //io counters of processes by watch and pid
+	opts     ProcessOptions
+}
+
+// NewProcess is factory of per-process collector
+func NewProcess(base Base, options json.RawMessage) (Collector, error) {
+	c := &Process{
+		Base:     base,
+		pidsFunc: process.PidsWithContext,
+		nameFunc: readProcName,
+		statFunc: readProcStat,
+		now:      time.Now,
+		cpu:      make(map[string]cpuSample),
+		io:       newCounters(),
+	}
+	if err := DecodeOptions(options, &c.opts); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i := range c.opts.Processes {
+		w := &c.opts.Processes[i]
+		if w.Name == "" {
+			return nil, errors.New("process name is not set")
+		}
+		w.Name = label(w.Name)
+		if names[w.Name] {
+			return nil, fmt.Errorf("process %q is watched twice", w.Name)
+		}
+		names[w.Name] = true
+		selectors := 0
+		for _, s := range []string{w.Pattern, w.PIDFile, w.Cgroup} {
+			if s != "" {
+				selectors++
+			}
+		}
+		if selectors != 1 {
+			return nil, fmt.Errorf("process %q: one of pattern, pid_file or cgroup must be set", w.Name)
+		}
+		if w.Pattern != "" {
+			re, err := regexp.Compile(w.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("process %q: %w", w.Name, err)
+			}
+			w.re = re
+		}
+		if w.Cgroup != "" && !filepath.IsAbs(w.Cgroup) {
+			w.Cgroup = filepath.Join(cgroupRoot, w.Cgroup)
+		}
+	}
+	return c, nil
+}
+
+func (c *Process) Collect(ctx context.Context) ([]storage.Metrics, error) {
+	now := c.now()
+	cpu := make(map[string]cpuSample, len(c.cpu))
+	defer func() {
+		c.cpu = cpu
+		c.io.rotate()
+	}()
+	var metrics []storage.Metrics
+	var errs []error
+	var names map[int32]string //names of all processes, read once for all patterns
+	for _, w := range c.opts.Processes {
+		var pids []int32
+		var err error
+		switch {
+		case w.re != nil:
+			if names == nil {
+				names, err = c.names(ctx)
+			}
+			for pid, name := range names {
+				if w.re.MatchString(name) {
+					pids = append(pids, pid)
+				}
+			}
+		case w.PIDFile != "":
+			pids, err = readPIDs(w.PIDFile)
+		default:
+			pids, err = readPIDs(filepath.Join(w.Cgroup, "cgroup.procs"))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("process %q: %w", w.Name, err))
+			continue
+		}
+		metrics = append(metrics, c.watchMetrics(ctx, w.Name, pids, now, cpu)...)
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// watchMetrics returns summed stat of processes of watch, cpu time of processes is saved into cpu
+func (c *Process) watchMetrics(ctx context.Context, name string, pids []int32, now time.Time, cpu map[string]cpuSample) []storage.Metrics {
+	var count, fds, threads int64
+	var rss uint64
+	var cpuPercent float64
+	cpuKnown := false
+	//sum of fds is not reported if fds of some processes aren't readable
+	fdsKnown := true
+	io := make(map[string]int64)
+	ioKnown := false
+	for _, pid := range pids {
+		st, err := c.statFunc(ctx, pid)
+		if err != nil {
+			//process has exited
+			continue
+		}
+		count++
+		rss += st.rss
+		if st.fds != nil {
+			fds += int64(*st.fds)
+		} else {
+			fdsKnown = false
+		}
+		threads += int64(st.threads)
+		key := name + "/" + strconv.Itoa(int(pid))
+		if prev, ok := c.cpu[key]; ok && now.After(prev.at) {
+			cpuPercent += max(st.cpu-prev.cpu, 0) / now.Sub(prev.at).Seconds() * 100
+			cpuKnown = true
+		}
+		cpu[key] = cpuSample{at: now, cpu: st.cpu}
+		if st.io == nil {
+			continue
+		}
+		for stat, v := range map[string]uint64{
+			"ProcessReadBytes":  st.io.ReadBytes,
+			"ProcessWriteBytes": st.io.WriteBytes,
+			"ProcessReadCount":  st.io.ReadCount,
+			"ProcessWriteCount": st.io.WriteCount,
+		} {
+			if delta, ok := c.io.delta(key+"/"+stat, v); ok {
+				io[stat] += delta
+				ioKnown = true
+			}
+		}
+	}
+	metrics := []storage.Metrics{
+		gauge("ProcessCount."+name, float64(count)),
+		gauge("ProcessRSS."+name, float64(rss)),
+		gauge("ProcessThreads."+name, float64(threads)),
+	}
+	if fdsKnown {
+		metrics = append(metrics, gauge("ProcessFDs."+name, float64(fds)))
+	}
+	if cpuKnown {
+		metrics = append(metrics, gauge("ProcessCPU."+name, cpuPercent))
+	}
+	if ioKnown {
+		for _, stat := range []string{"ProcessReadBytes", "ProcessWriteBytes", "ProcessReadCount", "ProcessWriteCount"} {
+			metrics = append(metrics, counter(stat+"."+name, io[stat]))
+		}
+	}
+	return metrics
+}
+
+// names returns names of all processes
+func (c *Process) names(ctx context.Context) (map[int32]string, error) {
+	pids, err := c.pidsFunc(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read processes: %w", err)
+	}
+	names := make(map[int32]string, len(pids))
+	for _, pid := range pids {
+		if name, err := c.nameFunc(ctx, pid); err == nil {
+			names[pid] = name
+		}
+	}
+	return names, nil
+}
+
+// readPIDs reads pids from pid file or cgroup.procs, one pid per line
+func readPIDs(filename string) ([]int32, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int32
+	for _, line := range strings.Fields(string(b)) {
+		pid, err := strconv.ParseInt(line, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid pid %q", filename, line)
+		}
+		pids = append(pids, int32(pid))
+	}
+	return pids, nil
+}
+
+func readProcName(ctx context.Context, pid int32) (string, error) {
+	p, err := process.NewProcessWithContext(ctx, pid)
+	if err != nil {
+		return "", err
+	}
+	return p.NameWithContext(ctx)
+}
+
+func readProcStat(ctx context.Context, pid int32) (procStat, error) {
+	p, err := process.NewProcessWithContext(ctx, pid)
+	if err != nil {
+		return procStat{}, err
+	}
+	times, err := p.TimesWithContext(ctx)
+	if err != nil {
+		return procStat{}, err
+	}
+	mem, err := p.MemoryInfoWithContext(ctx)
+	if err != nil {
+		return procStat{}, err
+	}
+	st := procStat{cpu: times.User + times.System, rss: mem.RSS}
+	//fds and io of processes of other users aren't readable by unprivileged agent
+	if fds, err := p.NumFDsWithContext(ctx); err == nil {
+		st.fds = &fds
+	}
+	if threads, err := p.NumThreadsWithContext(ctx); err == nil {
+		st.threads = threads
+	}
+	if io, err := p.IOCountersWithContext(ctx); err == nil {
+		st.io = io
+	}
+	return st, nil
+}
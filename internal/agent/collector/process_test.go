@@ -0,0 +1,143 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/shirou/gopsutil/process"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcess_Collect(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "db.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte("30\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("40\n41\n"), 0o644))
+	options, err := json.Marshal(ProcessOptions{Processes: []ProcessWatch{
+		{Name: "api", Pattern: "^api$"},
+		{Name: "db", PIDFile: pidFile},
+		{Name: "worker.slice", Cgroup: dir},
+	}})
+	require.NoError(t, err)
+	c, err := NewProcess(NewBase(NameProcess, time.Second), options)
+	require.NoError(t, err)
+	p := c.(*Process)
+
+	clock := time.Now()
+	p.now = func() time.Time { return clock }
+	p.pidsFunc = func(ctx context.Context) ([]int32, error) {
+		return []int32{10, 11, 20, 30}, nil
+	}
+	p.nameFunc = func(ctx context.Context, pid int32) (string, error) {
+		if pid == 20 {
+			return "api-gateway", nil
+		}
+		return "api", nil
+	}
+	cpuTime := 1.0
+	fdsUnreadable := int32(0)
+	p.statFunc = func(ctx context.Context, pid int32) (procStat, error) {
+		if pid == 41 {
+			return procStat{}, errors.New("process has exited")
+		}
+		fds := int32(5)
+		st := procStat{
+			cpu:     cpuTime,
+			rss:     100,
+			fds:     &fds,
+			threads: 2,
+			io:      &process.IOCountersStat{ReadBytes: uint64(cpuTime * 1000)},
+		}
+		if pid == fdsUnreadable {
+			st.fds = nil
+		}
+		return st, nil
+	}
+	gauges := func(name string, count float64) []storage.Metrics {
+		return []storage.Metrics{
+			gauge("ProcessCount."+name, count),
+			gauge("ProcessRSS."+name, 100*count),
+			gauge("ProcessThreads."+name, 2*count),
+			gauge("ProcessFDs."+name, 5*count),
+		}
+	}
+
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	//processes 10, 11 and 30 are named api
+	want := append(append(gauges("api", 3), gauges("db", 1)...), gauges("worker.slice", 1)...)
+	require.Equal(t, want, metrics)
+
+	//cpu and io are reported from second poll
+	clock = clock.Add(2 * time.Second)
+	cpuTime = 2.0
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, metrics, gauge("ProcessCPU.db", 50))
+	require.Contains(t, metrics, gauge("ProcessCPU.api", 150))
+	require.Contains(t, metrics, counter("ProcessReadBytes.db", 1000))
+	require.Contains(t, metrics, counter("ProcessWriteBytes.worker.slice", 0))
+
+	//fds are not reported if they aren't readable for some process of watch
+	fdsUnreadable = 11
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, metrics, gauge("ProcessFDs.db", 5))
+	for _, m := range metrics {
+		require.NotEqual(t, "ProcessFDs.api", m.ID)
+	}
+}
+
+func TestProcess_CollectMissingPIDFile(t *testing.T) {
+	c, err := NewProcess(NewBase(NameProcess, time.Second), json.RawMessage(`{"processes": [{"name": "db", "pid_file": "/not/exists.pid"}]}`))
+	require.NoError(t, err)
+	metrics, err := c.Collect(context.Background())
+	require.ErrorContains(t, err, `process "db"`)
+	require.Empty(t, metrics)
+}
+
+func TestNewProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		wantErr string
+	}{
+		{
+			name:    "name is not set",
+			options: `{"processes": [{"pattern": "api"}]}`,
+			wantErr: "process name is not set",
+		},
+		{
+			name:    "no selector",
+			options: `{"processes": [{"name": "api"}]}`,
+			wantErr: "one of pattern, pid_file or cgroup must be set",
+		},
+		{
+			name:    "two selectors",
+			options: `{"processes": [{"name": "api", "pattern": "api", "pid_file": "/run/api.pid"}]}`,
+			wantErr: "one of pattern, pid_file or cgroup must be set",
+		},
+		{
+			name:    "invalid pattern",
+			options: `{"processes": [{"name": "api", "pattern": "("}]}`,
+			wantErr: "missing closing )",
+		},
+		{
+			name:    "watched twice",
+			options: `{"processes": [{"name": "api", "pattern": "a"}, {"name": "api", "pattern": "b"}]}`,
+			wantErr: "watched twice",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProcess(NewBase(NameProcess, time.Second), json.RawMessage(tt.options))
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
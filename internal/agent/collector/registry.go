@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Factory creates collector with base and options from config
+type Factory func(base Base, options json.RawMessage) (Collector, error)
+
+// registration factory of collector and whether collector runs if config doesn't enable it
+type registration struct {
+	factory Factory
+	enabled bool
+}
+
+// Registry collectors available to agent
+type Registry struct {
+	collectors map[string]registration
+}
+
+// NewRegistry is factory method
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]registration)}
+}
+
+// Register adds collector factory, enabled collectors run unless config disables them
+func (r *Registry) Register(name string, f Factory, enabled bool) {
+	r.collectors[name] = registration{factory: f, enabled: enabled}
+}
+
+// Names returns sorted names of registered collectors
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Build creates enabled collectors sorted by name, interval is used for collectors without own interval
+func (r *Registry) Build(configs map[string]Config, interval time.Duration) ([]Collector, error) {
+	for name := range configs {
+		if _, ok := r.collectors[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	var collectors []Collector
+	for _, name := range r.Names() {
+		reg := r.collectors[name]
+		cfg := configs[name]
+		enabled := reg.enabled
+		if cfg.Enabled != nil {
+			enabled = *cfg.Enabled
+		}
+		if !enabled {
+			continue
+		}
+		d := interval
+		if cfg.Interval > 0 {
+			d = time.Duration(cfg.Interval) * time.Second
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("collector %q: interval is not set", name)
+		}
+		c, err := reg.factory(NewBase(name, d), cfg.Options)
+		if err != nil {
+			return nil, fmt.Errorf("collector %q: %w", name, err)
+		}
+		collectors = append(collectors, c)
+	}
+	return collectors, nil
+}
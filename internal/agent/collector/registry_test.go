@@ -0,0 +1,119 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// static collector returning configured metric
+type static struct {
+	Base
+	Value float64 `json:"value"`
+}
+
+func (c *static) Collect(ctx context.Context) ([]storage.Metrics, error) {
+	return []storage.Metrics{gauge(c.Name(), c.Value)}, nil
+}
+
+func newStatic(base Base, options json.RawMessage) (Collector, error) {
+	c := &static{Base: base, Value: 1}
+	if err := DecodeOptions(options, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func TestRegistry_Build(t *testing.T) {
+	r := NewRegistry()
+	r.Register("b", newStatic, true)
+	r.Register("a", newStatic, true)
+	r.Register("off", newStatic, false)
+	enabled := true
+	disabled := false
+
+	tests := []struct {
+		configs   map[string]Config
+		name      string
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name:      "registry defaults",
+			wantNames: []string{"a", "b"},
+		},
+		{
+			name: "enabled and disabled by config",
+			configs: map[string]Config{
+				"a":   {Enabled: &disabled},
+				"off": {Enabled: &enabled},
+			},
+			wantNames: []string{"b", "off"},
+		},
+		{
+			name:    "unknown collector",
+			configs: map[string]Config{"unknown": {}},
+			wantErr: `unknown collector "unknown"`,
+		},
+		{
+			name:    "unknown option",
+			configs: map[string]Config{"a": {Options: json.RawMessage(`{"size":1}`)}},
+			wantErr: `collector "a"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collectors, err := r.Build(tt.configs, time.Second)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			var names []string
+			for _, c := range collectors {
+				names = append(names, c.Name())
+			}
+			require.Equal(t, tt.wantNames, names)
+		})
+	}
+}
+
+func TestRegistry_BuildConfig(t *testing.T) {
+	r := NewRegistry()
+	r.Register("a", newStatic, true)
+	r.Register("b", func(base Base, options json.RawMessage) (Collector, error) {
+		return nil, errors.New("not supported")
+	}, false)
+
+	collectors, err := r.Build(map[string]Config{
+		"a": {Interval: 5, Options: json.RawMessage(`{"value":2}`)},
+	}, time.Second)
+	require.NoError(t, err)
+	require.Len(t, collectors, 1)
+	require.Equal(t, 5*time.Second, collectors[0].Interval())
+	metrics, err := collectors[0].Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []storage.Metrics{gauge("a", 2)}, metrics)
+
+	enabled := true
+	_, err = r.Build(map[string]Config{"b": {Enabled: &enabled}}, time.Second)
+	require.ErrorContains(t, err, "not supported")
+	_, err = r.Build(nil, 0)
+	require.ErrorContains(t, err, "interval is not set")
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := newStatic(NewBase("a", 10*time.Millisecond), nil)
+	require.NoError(t, err)
+	ch := Run(ctx, c)
+	require.Equal(t, gauge("a", 1), <-ch)
+	cancel()
+	for range ch {
+	}
+}
@@ -0,0 +1,162 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+)
+
+// NameRuntime name of runtime/metrics collector
+const NameRuntime string = "runtime"
+
+// defaultRuntimeMetrics runtime metrics collected if options don't set them
+var defaultRuntimeMetrics = []string{
+	"/sched/goroutines:goroutines",
+	"/sched/gomaxprocs:threads",
+	"/sched/latencies:seconds",
+	"/sched/pauses/total/gc:seconds",
+	"/gc/cycles/total:gc-cycles",
+	"/sync/mutex/wait/total:seconds",
+}
+
+// RuntimeOptions options of runtime/metrics collector
+type RuntimeOptions struct {
+	Metrics   []string  `json:"metrics"`   //names of runtime/metrics samples
+	Quantiles []float64 `json:"quantiles"` //quantiles reported for histograms
+}
+
+// Runtime collects samples of runtime/metrics package. Metric name is "runtime." followed by sample name
+// with "." instead of "/", e.g. runtime.sched.goroutines:goroutines. Cumulative integer samples are counters
+// with increments since previous poll, other numbers are gauges. Histograms are converted to gauges of quantiles
+// <name>.p<quantile> and counter <name>.count of samples observed since previous poll
+type Runtime struct {
+	Base
+	samples    []metrics.Sample
+	cumulative map[string]bool     //samples which are totals since program start
+	hists      map[string][]uint64 //bucket counts of histograms at previous poll
+	counters   *counters
+	opts       RuntimeOptions
+}
+
+// NewRuntime is factory of runtime/metrics collector
+func NewRuntime(base Base, options json.RawMessage) (Collector, error) {
+	c := &Runtime{
+		Base:       base,
+		cumulative: make(map[string]bool),
+		hists:      make(map[string][]uint64),
+		counters:   newCounters(),
+		opts: RuntimeOptions{
+			Metrics:   defaultRuntimeMetrics,
+			Quantiles: []float64{0.5, 0.9, 0.99},
+		},
+	}
+	if err := DecodeOptions(options, &c.opts); err != nil {
+		return nil, err
+	}
+	for _, q := range c.opts.Quantiles {
+		if q <= 0 || q > 1 {
+			return nil, fmt.Errorf("quantile %v is out of (0, 1]", q)
+		}
+	}
+	supported := make(map[string]metrics.Description)
+	for _, d := range metrics.All() {
+		supported[d.Name] = d
+	}
+	for _, name := range c.opts.Metrics {
+		d, ok := supported[name]
+		if !ok {
+			return nil, fmt.Errorf("runtime metric %q is not supported", name)
+		}
+		c.cumulative[name] = d.Cumulative
+		c.samples = append(c.samples, metrics.Sample{Name: name})
+	}
+	return c, nil
+}
+
+func (c *Runtime) Collect(ctx context.Context) ([]storage.Metrics, error) {
+	defer c.counters.rotate()
+	metrics.Read(c.samples)
+	var result []storage.Metrics
+	for _, s := range c.samples {
+		name := runtimeMetricName(s.Name)
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			v := s.Value.Uint64()
+			if !c.cumulative[s.Name] {
+				result = append(result, gauge(name, float64(v)))
+			} else if delta, ok := c.counters.delta(name, v); ok {
+				result = append(result, counter(name, delta))
+			}
+		case metrics.KindFloat64:
+			result = append(result, gauge(name, s.Value.Float64()))
+		case metrics.KindFloat64Histogram:
+			result = append(result, c.histogram(name, s.Value.Float64Histogram())...)
+		}
+	}
+	return result, nil
+}
+
+// histogram returns quantiles and count of values observed since previous poll, nothing is returned on first poll
+func (c *Runtime) histogram(name string, h *metrics.Float64Histogram) []storage.Metrics {
+	prev, known := c.hists[name]
+	c.hists[name] = append([]uint64(nil), h.Counts...)
+	if !known || len(prev) != len(h.Counts) {
+		return nil
+	}
+	counts := make([]uint64, len(h.Counts))
+	var total uint64
+	for i := range h.Counts {
+		if h.Counts[i] >= prev[i] {
+			counts[i] = h.Counts[i] - prev[i]
+		}
+		total += counts[i]
+	}
+	result := []storage.Metrics{counter(name+".count", int64(total))}
+	if total == 0 {
+		return result
+	}
+	for _, q := range c.opts.Quantiles {
+		result = append(result, gauge(name+".p"+quantileLabel(q), quantile(h.Buckets, counts, total, q)))
+	}
+	return result
+}
+
+// quantile returns upper boundary of bucket containing quantile q, lower boundary for last unbounded bucket
+func quantile(buckets []float64, counts []uint64, total uint64, q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(total)))
+	var cum uint64
+	for i, n := range counts {
+		cum += n
+		if cum < rank {
+			continue
+		}
+		if math.IsInf(buckets[i+1], 1) {
+			return buckets[i]
+		}
+		return buckets[i+1]
+	}
+	return buckets[len(buckets)-1]
+}
+
+// quantileLabel returns digits of quantile after point, e.g. 50 for 0.5 and 999 for 0.999
+func quantileLabel(q float64) string {
+	if q == 1 {
+		return "100"
+	}
+	s := strings.TrimPrefix(strconv.FormatFloat(q, 'f', -1, 64), "0.")
+	if len(s) == 1 {
+		s += "0"
+	}
+	return s
+}
+
+// runtimeMetricName converts name of runtime/metrics sample to metric name
+func runtimeMetricName(name string) string {
+	return "runtime." + strings.ReplaceAll(strings.TrimPrefix(name, "/"), "/", ".")
+}
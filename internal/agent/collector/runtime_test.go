@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntime_Collect(t *testing.T) {
+	c, err := NewRuntime(NewBase(NameRuntime, time.Second), nil)
+	require.NoError(t, err)
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	ids := func(metrics []storage.Metrics) []string {
+		var ids []string
+		for _, m := range metrics {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+	//counters and histograms are reported from second poll
+	require.Equal(t, []string{
+		"runtime.sched.goroutines:goroutines",
+		"runtime.sched.gomaxprocs:threads",
+		"runtime.sync.mutex.wait.total:seconds",
+	}, ids(metrics))
+
+	runtime.GC()
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, ids(metrics), "runtime.sched.latencies:seconds.count")
+	require.Contains(t, ids(metrics), "runtime.sched.pauses.total.gc:seconds.count")
+	for _, m := range metrics {
+		require.NoError(t, storage.NewNameValidator().Validate(storage.MetricName(m.ID)))
+		if m.ID == "runtime.gc.cycles.total:gc-cycles" {
+			require.GreaterOrEqual(t, m.ActualValue, int64(1))
+		}
+	}
+}
+
+func TestRuntime_histogram(t *testing.T) {
+	c, err := NewRuntime(NewBase(NameRuntime, time.Second), json.RawMessage(`{"quantiles": [0.5, 0.99]}`))
+	require.NoError(t, err)
+	r := c.(*Runtime)
+	buckets := []float64{math.Inf(-1), 0.001, 0.01, math.Inf(1)}
+	require.Empty(t, r.histogram("h", &metrics.Float64Histogram{Counts: []uint64{10, 0, 0}, Buckets: buckets}))
+	//100 new values: 98 are up to 0.001, 2 are more than 0.01
+	require.Equal(t, []storage.Metrics{
+		counter("h.count", 100),
+		gauge("h.p50", 0.001),
+		gauge("h.p99", 0.01),
+	}, r.histogram("h", &metrics.Float64Histogram{Counts: []uint64{108, 0, 2}, Buckets: buckets}))
+	//no new values
+	require.Equal(t, []storage.Metrics{
+		counter("h.count", 0),
+	}, r.histogram("h", &metrics.Float64Histogram{Counts: []uint64{108, 0, 2}, Buckets: buckets}))
+}
+
+func TestNewRuntime(t *testing.T) {
+	_, err := NewRuntime(NewBase(NameRuntime, time.Second), json.RawMessage(`{"metrics": ["/not/exists:units"]}`))
+	require.ErrorContains(t, err, `runtime metric "/not/exists:units" is not supported`)
+	_, err = NewRuntime(NewBase(NameRuntime, time.Second), json.RawMessage(`{"quantiles": [1.5]}`))
+	require.ErrorContains(t, err, "quantile 1.5 is out of (0, 1]")
+}
+
+func Test_quantileLabel(t *testing.T) {
+	for q, want := range map[float64]string{0.05: "05", 0.5: "50", 0.9: "90", 0.99: "99", 0.999: "999", 1: "100"} {
+		require.Equal(t, want, quantileLabel(q))
+	}
+}
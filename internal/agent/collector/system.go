@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/mem"
+)
+
+// NameSystem name of system memory and cpu collector
+const NameSystem string = "system"
+
+// cpu time metrics, percent of cpu time spent in state since previous poll
+const (
+	MetricNameCPUUser   string = "CPUuser"
+	MetricNameCPUSystem string = "CPUsystem"
+	MetricNameCPUIowait string = "CPUiowait"
+	MetricNameCPUSteal  string = "CPUsteal"
+)
+
+// System collects total and free memory, utilization of every cpu and breakdown of cpu time
+type System struct {
+	Base
+	vmemReadFunc  func() (*mem.VirtualMemoryStat, error)                       //reads virtual memory stat
+	cpuReadFunc   func(interval time.Duration, percpu bool) ([]float64, error) //reads cpu utilization
+	timesReadFunc func(percpu bool) ([]cpu.TimesStat, error)                   //reads cpu times, breakdown is not collected if nil
+	lastTimes     *cpu.TimesStat                                               //cpu times of previous poll
+}
+
+// NewSystem is factory method
+func NewSystem(base Base, vmemReadFunc func() (*mem.VirtualMemoryStat, error), cpuReadFunc func(interval time.Duration, percpu bool) ([]float64, error), timesReadFunc func(percpu bool) ([]cpu.TimesStat, error)) *System {
+	return &System{
+		Base:          base,
+		vmemReadFunc:  vmemReadFunc,
+		cpuReadFunc:   cpuReadFunc,
+		timesReadFunc: timesReadFunc,
+	}
+}
+
+func (c *System) Collect(ctx context.Context) ([]storage.Metrics, error) {
+	vmem, err := c.vmemReadFunc()
+	if err != nil {
+		return nil, fmt.Errorf("read memory: %w", err)
+	}
+	metrics := []storage.Metrics{
+		gauge(string(storage.MetricNameTotalMemory), float64(vmem.Total)),
+		gauge(string(storage.MetricNameFreeMemory), float64(vmem.Free)),
+	}
+	vcpu, err := c.cpuReadFunc(0, true)
+	if err != nil {
+		return metrics, fmt.Errorf("read cpu: %w", err)
+	}
+	if len(vcpu) == 0 {
+		return metrics, errors.New("read cpu: no utilization")
+	}
+	//CPUutilization1..N
+	for i, v := range vcpu {
+		metrics = append(metrics, gauge("CPUutilization"+strconv.Itoa(i+1), v))
+	}
+	if c.timesReadFunc == nil {
+		return metrics, nil
+	}
+	times, err := c.timesReadFunc(false)
+	if err != nil {
+		return metrics, fmt.Errorf("read cpu times: %w", err)
+	}
+	if len(times) == 0 {
+		return metrics, errors.New("read cpu times: no times")
+	}
+	return append(metrics, c.breakdown(times[0])...), nil
+}
+
+// breakdown returns percent of cpu time spent in states since previous poll, nothing is returned on first poll
+func (c *System) breakdown(t cpu.TimesStat) []storage.Metrics {
+	last := c.lastTimes
+	c.lastTimes = &t
+	if last == nil {
+		return nil
+	}
+	total := t.Total() - last.Total()
+	if total <= 0 {
+		return nil
+	}
+	percent := func(cur float64, prev float64) float64 {
+		return max(cur-prev, 0) / total * 100
+	}
+	return []storage.Metrics{
+		gauge(MetricNameCPUUser, percent(t.User, last.User)),
+		gauge(MetricNameCPUSystem, percent(t.System, last.System)),
+		gauge(MetricNameCPUIowait, percent(t.Iowait, last.Iowait)),
+		gauge(MetricNameCPUSteal, percent(t.Steal, last.Steal)),
+	}
+}
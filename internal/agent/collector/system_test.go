@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystem_Collect(t *testing.T) {
+	times := []cpu.TimesStat{
+		{User: 10, System: 10, Idle: 80},
+		{User: 30, System: 15, Idle: 130, Iowait: 20, Steal: 5},
+	}
+	poll := 0
+	c := NewSystem(NewBase(NameSystem, time.Second),
+		func() (*mem.VirtualMemoryStat, error) {
+			return &mem.VirtualMemoryStat{Total: 100, Free: 40}, nil
+		},
+		func(interval time.Duration, percpu bool) ([]float64, error) {
+			require.True(t, percpu)
+			return []float64{10, 20, 30}, nil
+		},
+		func(percpu bool) ([]cpu.TimesStat, error) {
+			poll++
+			return times[poll-1 : poll], nil
+		},
+	)
+	base := []storage.Metrics{
+		gauge("TotalMemory", 100),
+		gauge("FreeMemory", 40),
+		gauge("CPUutilization1", 10),
+		gauge("CPUutilization2", 20),
+		gauge("CPUutilization3", 30),
+	}
+
+	//breakdown needs previous times
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, base, metrics)
+
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, append(base,
+		gauge(MetricNameCPUUser, 20),
+		gauge(MetricNameCPUSystem, 5),
+		gauge(MetricNameCPUIowait, 20),
+		gauge(MetricNameCPUSteal, 5),
+	), metrics)
+}
+
+func TestSystem_CollectError(t *testing.T) {
+	c := NewSystem(NewBase(NameSystem, time.Second),
+		func() (*mem.VirtualMemoryStat, error) {
+			return &mem.VirtualMemoryStat{Total: 100, Free: 40}, nil
+		},
+		func(interval time.Duration, percpu bool) ([]float64, error) {
+			return nil, errors.New("no cpu")
+		},
+		nil,
+	)
+	metrics, err := c.Collect(context.Background())
+	require.ErrorContains(t, err, "no cpu")
+	//memory is reported anyway
+	require.Len(t, metrics, 2)
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/caarlos0/env/v6"
+	"github.com/esafronov/yp-metrics/internal/agent/collector"
 )
 
 type AppParams struct {
@@ -19,6 +20,10 @@ type AppParams struct {
 	CryptoKey            *string `env:"CRYPTO_KEY" json:"crypto_key"`           //filepath to RSA public key
 	Config               *string `env:"CONFIG" json:"-"`                        //filepath to config file
 	UseGRPC              *bool   `env:"USE_GRPC"`                               //use gRPC client to send metrics (http client by default)
+	Collectors           *string `env:"COLLECTORS" json:"-"`                    //comma separated list of enabled collectors, overrides collectors config
+	PushAddress          *string `env:"PUSH_ADDRESS" json:"push_address"`       //address of local push API, host:port on loopback or unix:<path>
+
+	CollectorConfigs map[string]collector.Config `json:"collectors"` //config of collectors by name
 }
 
 var Params *AppParams = &AppParams{}
@@ -51,6 +56,8 @@ var profileServerAddressFlag *string
 var cryptoKeyFlag *string
 var configFlag *string
 var useGRPCFlag *bool
+var collectorsFlag *string
+var pushAddressFlag *string
 
 func parseFlags() {
 	serverAddressFlag = flag.String("a", "localhost:8080", "address and port to send reports")
@@ -61,6 +68,8 @@ func parseFlags() {
 	profileServerAddressFlag = flag.String("ad", "", "profile server address to listen")
 	cryptoKeyFlag = flag.String("crypto-key", "", "Full filepath to RSA private key")
 	useGRPCFlag = flag.Bool("g", false, "Use gRPC client to send metrics")
+	collectorsFlag = flag.String("collectors", "", "comma separated list of enabled collectors, overrides collectors config")
+	pushAddressFlag = flag.String("push-address", "", "address of local push API, host:port on loopback or unix:<path>, empty = disabled")
 	configFlag = flag.String("config", "", "filepath to config file")
 	flag.StringVar(configFlag, "c", *configFlag, "alias for -config")
 	flag.Parse()
@@ -97,6 +106,12 @@ func SetFlags() {
 	if Params.UseGRPC == nil {
 		Params.UseGRPC = useGRPCFlag
 	}
+	if Params.Collectors == nil {
+		Params.Collectors = collectorsFlag
+	}
+	if Params.PushAddress == nil {
+		Params.PushAddress = pushAddressFlag
+	}
 	if Params.Config == nil {
 		Params.Config = configFlag
 	}
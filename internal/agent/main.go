@@ -9,6 +9,8 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 
 	_ "net/http/pprof" // подключаем пакет pprof
 
+	"github.com/esafronov/yp-metrics/internal/agent/collector"
 	"github.com/esafronov/yp-metrics/internal/agent/config"
 	"github.com/esafronov/yp-metrics/internal/logger"
 	"github.com/esafronov/yp-metrics/internal/pprofserv"
@@ -38,11 +41,13 @@ type Agent struct {
 	memReadFunc   func(m *runtime.MemStats)
 	vmemReadFunc  func() (*mem.VirtualMemoryStat, error)
 	cpuReadFunc   func(interval time.Duration, percpu bool) ([]float64, error)
+	cpuTimesFunc  func(percpu bool) ([]cpu.TimesStat, error)
 	serverAddress string
 	memStats      runtime.MemStats
 	secretKey     string
 	cryptoKey     string
 	metricsClient pb.MetricsClient
+	collectors    []collector.Collector //sources of metrics, built-in collectors are used if nil
 }
 
 // NewAgent is fabric method
@@ -55,6 +60,7 @@ func NewAgent(s storage.Repositories, serverAddress string, opts ...func(a *Agen
 		memReadFunc:   runtime.ReadMemStats,
 		vmemReadFunc:  mem.VirtualMemory,
 		cpuReadFunc:   cpu.Percent,
+		cpuTimesFunc:  cpu.Times,
 	}
 	for _, f := range opts {
 		f(a)
@@ -97,6 +103,13 @@ func OptionWithCpuReadFunc(cpuReadFunc func(interval time.Duration, percpu bool)
 	}
 }
 
+// OptionWithCpuTimesFunc option function to configure Agent to use cpuTimesFunc
+func OptionWithCpuTimesFunc(cpuTimesFunc func(percpu bool) ([]cpu.TimesStat, error)) func(a *Agent) {
+	return func(a *Agent) {
+		a.cpuTimesFunc = cpuTimesFunc
+	}
+}
+
 // OptionWithMetricsClient option function to configure Agent to use metricsClient
 func OptionWithMetricsClient(client pb.MetricsClient) func(a *Agent) {
 	return func(a *Agent) {
@@ -107,6 +120,13 @@ func OptionWithMetricsClient(client pb.MetricsClient) func(a *Agent) {
 	}
 }
 
+// OptionWithCollectors option function to configure Agent to collect metrics by collectors
+func OptionWithCollectors(collectors ...collector.Collector) func(a *Agent) {
+	return func(a *Agent) {
+		a.collectors = collectors
+	}
+}
+
 // Run initialize and run main buisness logic:
 //
 // Get env/flags params, initialize repository, runs routine for collecting and sending metrics
@@ -127,6 +147,8 @@ func Run() {
 		zap.String("SecretKey", *params.SecretKey),
 		zap.String("CryptoKey", *params.CryptoKey),
 		zap.Bool("UseGRPC", *params.UseGRPC),
+		zap.String("Collectors", *params.Collectors),
+		zap.String("PushAddress", *params.PushAddress),
 		zap.String("Config", *params.Config),
 	)
 	//run profile server if env/flag is set
@@ -165,6 +187,7 @@ func Run() {
 		OptionWithSecretKey(*params.SecretKey),
 		OptionWithCryptoKey(*params.CryptoKey),
 		OptionWithCpuReadFunc(cpu.Percent),
+		OptionWithCpuTimesFunc(cpu.Times),
 		OptionWithMemReadFunc(runtime.ReadMemStats),
 		OptionWithVMemReadFunc(mem.VirtualMemory),
 		OptionWithMetricsClient(metricsClient),
@@ -173,7 +196,16 @@ func Run() {
 	if params.PollInterval == nil {
 		panic("pollInterval is null")
 	}
+	configs := collectorConfigs(params, a.registry().Names())
+	if err := a.SetupCollectors(configs, time.Duration(*params.PollInterval)*time.Second); err != nil {
+		log.Fatalf("failed to set up collectors: %v", err)
+	}
 	a.CollectMetrics(ctx, params.PollInterval)
+	if *params.PushAddress != "" {
+		if err := a.ServePush(ctx, *params.PushAddress); err != nil {
+			log.Fatalf("failed to serve push api: %v", err)
+		}
+	}
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGABRT, syscall.SIGQUIT)
 	go func() {
@@ -185,3 +217,30 @@ func Run() {
 	a.SendMetrics(ctx, params.ReportInterval, params.RateLimit)
 	fmt.Println("exit")
 }
+
+// collectorConfigs returns config of collectors, list of enabled collectors overrides it if set
+func collectorConfigs(params *config.AppParams, names []string) map[string]collector.Config {
+	configs := make(map[string]collector.Config, len(params.CollectorConfigs))
+	for name, cfg := range params.CollectorConfigs {
+		configs[name] = cfg
+	}
+	if params.Collectors == nil || *params.Collectors == "" {
+		return configs
+	}
+	enabled := strings.Split(*params.Collectors, ",")
+	for i, name := range enabled {
+		name = strings.TrimSpace(name)
+		enabled[i] = name
+		//unknown names are reported by registry
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		cfg := configs[name]
+		cfg.Enabled = new(bool)
+		*cfg.Enabled = slices.Contains(enabled, name)
+		configs[name] = cfg
+	}
+	return configs
+}
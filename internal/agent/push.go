@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/esafronov/yp-metrics/internal/compress"
+	"github.com/esafronov/yp-metrics/internal/handlers"
+	"github.com/esafronov/yp-metrics/internal/logger"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// pushRouter returns router of local push API, metrics pushed by applications are stored into agent repository
+// and sent to server with collected metrics on report
+func (a *Agent) pushRouter() chi.Router {
+	h := handlers.NewAPIHandler(a.storage)
+	r := chi.NewRouter()
+	r.Use(logger.RequestLogger)
+	r.Use(compress.GzipCompressing)
+	r.Post("/update/", h.UpdateJSON) //update metric with json request
+	r.Post("/updates/", h.Updates)   //batch updating
+	return r
+}
+
+// listenPush listens address of push API, address is unix:<path> of unix socket or host:port of loopback interface,
+// push API isn't authenticated so it is not served on other interfaces
+func listenPush(address string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		//socket left by previous run of agent prevents listening
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", path)
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("push address %q is not loopback address", address)
+	}
+	return net.Listen("tcp", address)
+}
+
+// ServePush runs routine serving local push API on address until ctx is done
+func (a *Agent) ServePush(ctx context.Context, address string) error {
+	l, err := listenPush(address)
+	if err != nil {
+		return err
+	}
+	srv := http.Server{Handler: a.pushRouter()}
+	go func() {
+		<-ctx.Done()
+		if err := srv.Shutdown(context.Background()); err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}()
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Log.Error("serve push api", zap.Error(err))
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_pushRouter(t *testing.T) {
+	s := storage.NewMemStorage()
+	ctx := context.Background()
+	require.NoError(t, s.Insert(ctx, "Orders", storage.NewMetricCounter(int64(2))))
+	a := NewAgent(s, "")
+	ts := httptest.NewServer(a.pushRouter())
+	defer ts.Close()
+
+	post := func(path, body string) int {
+		res, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res.StatusCode
+	}
+	require.Equal(t, http.StatusOK, post("/update/", `{"id": "QueueSize", "type": "gauge", "value": 12.5}`))
+	require.Equal(t, http.StatusOK, post("/updates/", `[{"id": "Orders", "type": "counter", "delta": 3}, {"id": "Latency", "type": "gauge", "value": 0.2}]`))
+	require.Equal(t, http.StatusBadRequest, post("/updates/", `[{"id": "Queue/Size", "type": "gauge", "value": 1}]`))
+
+	items, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	values := make(map[storage.MetricName]interface{}, len(items))
+	for name, m := range items {
+		values[name] = m.GetValue()
+	}
+	require.Equal(t, map[storage.MetricName]interface{}{
+		"QueueSize": 12.5,
+		"Orders":    int64(5),
+		"Latency":   0.2,
+	}, values)
+}
+
+func TestAgent_ServePush(t *testing.T) {
+	a := NewAgent(storage.NewMemStorage(), "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.ErrorContains(t, a.ServePush(ctx, "0.0.0.0:0"), "is not loopback address")
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	require.NoError(t, a.ServePush(ctx, "unix:"+socket))
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	res, err := client.Post("http://agent/update/", "application/json", strings.NewReader(`{"id": "QueueSize", "type": "gauge", "value": 1}`))
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	m, err := a.storage.Get(ctx, "QueueSize")
+	require.NoError(t, err)
+	require.Equal(t, 1.0, m.GetValue())
+}
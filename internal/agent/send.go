@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/esafronov/yp-metrics/internal/compress"
+	"github.com/esafronov/yp-metrics/internal/dedup"
 	"github.com/esafronov/yp-metrics/internal/encrypt"
 	pb "github.com/esafronov/yp-metrics/internal/grpc/proto"
 	"github.com/esafronov/yp-metrics/internal/logger"
@@ -126,6 +127,12 @@ func (a *Agent) sendReportInBatch(ctx context.Context) error {
 	//header Accept-Encoding : gzip will be added automatically, so not need to add
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Content-Encoding", "gzip")
+	//batch id is the same for all tries, so server doesn't apply batch twice if response was lost
+	batchID, err := dedup.NewBatchID()
+	if err != nil {
+		return fmt.Errorf("batch id: %w", err)
+	}
+	req.Header.Set(dedup.HeaderBatchID, batchID)
 	res, err := retry.DoRequest(req)
 	if err != nil {
 		return fmt.Errorf("do request: %w", err)
@@ -295,6 +302,11 @@ func (a *Agent) sendReportInBatchGRPC(ctx context.Context) error {
 		newMD := metadata.Pairs(signing.HeaderSignatureKey, signature)
 		ctx = metadata.NewOutgoingContext(ctx, metadata.Join(send, newMD))
 	}
+	batchID, err := dedup.NewBatchID()
+	if err != nil {
+		return fmt.Errorf("batch id: %w", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, dedup.MetadataBatchID, batchID)
 	if _, err := a.metricsClient.BatchUpdate(ctx, in, grpc.UseCompressor(gzip.Name)); err != nil {
 		return fmt.Errorf("batch update error %w", err)
 	}
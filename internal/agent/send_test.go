@@ -44,11 +44,9 @@ func TestAgent_SendMetrics(t *testing.T) {
 		{
 			name: "send gauge Lookups 1.200000",
 			a: &Agent{
-				storage: &storage.MemStorage{
-					Values: map[storage.MetricName]storage.Metric{
-						"Lookups": storage.NewMetricGauge(float64(1.200000)),
-					},
-				},
+				storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+					"Lookups": storage.NewMetricGauge(float64(1.200000)),
+				}),
 				chSend: make(chan storage.Metrics),
 			},
 			reportInterval: 1,
@@ -68,11 +66,9 @@ func TestAgent_SendMetrics(t *testing.T) {
 		{
 			name: "send counter PollCount 1",
 			a: &Agent{
-				storage: &storage.MemStorage{
-					Values: map[storage.MetricName]storage.Metric{
-						"PollCount": storage.NewMetricCounter(int64(1)),
-					},
-				},
+				storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+					"PollCount": storage.NewMetricCounter(int64(1)),
+				}),
 				chSend: make(chan storage.Metrics),
 			},
 			reportInterval: 1,
@@ -93,12 +89,10 @@ func TestAgent_SendMetrics(t *testing.T) {
 			name:      "batch send gauge metrics",
 			secretKey: "mypass",
 			a: &Agent{
-				storage: &storage.MemStorage{
-					Values: map[storage.MetricName]storage.Metric{
-						"Lookups": storage.NewMetricGauge(float64(1.200000)),
-						"Test":    storage.NewMetricGauge(float64(1.0002)),
-					},
-				},
+				storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+					"Lookups": storage.NewMetricGauge(float64(1.200000)),
+					"Test":    storage.NewMetricGauge(float64(1.0002)),
+				}),
 				chSend:    make(chan storage.Metrics),
 				secretKey: "mypass",
 			},
@@ -124,12 +118,10 @@ func TestAgent_SendMetrics(t *testing.T) {
 			name:      "batch send counter metrics",
 			secretKey: "",
 			a: &Agent{
-				storage: &storage.MemStorage{
-					Values: map[storage.MetricName]storage.Metric{
-						"PollCount": storage.NewMetricCounter(int64(1)),
-						"testCount": storage.NewMetricCounter(int64(2)),
-					},
-				},
+				storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+					"PollCount": storage.NewMetricCounter(int64(1)),
+					"testCount": storage.NewMetricCounter(int64(2)),
+				}),
 				chSend: make(chan storage.Metrics),
 			},
 			reportInterval: 1,
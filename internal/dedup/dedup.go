@@ -0,0 +1,147 @@
+// Package dedup implements window of processed batch IDs, so batch retried by agent
+// after its response was lost is acknowledged without being applied twice
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderBatchID http header with batch identifier
+const HeaderBatchID string = "X-Batch-ID"
+
+// MetadataBatchID gRPC metadata key with batch identifier
+const MetadataBatchID string = "x-batch-id"
+
+// maxIDLength max length of batch identifier
+const maxIDLength int = 128
+
+var ErrInvalidID = errors.New("batch id is too long")
+
+// NewBatchID returns random batch identifier
+func NewBatchID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ValidateID checks batch identifier got from request
+func ValidateID(id string) error {
+	if len(id) > maxIDLength {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+// entry batch which is being processed or was processed
+type entry struct {
+	done    chan struct{} //closed when processing is finished
+	applied time.Time
+	ok      bool
+}
+
+// Window remembers batches applied within ttl, at most size of them
+type Window struct {
+	entries map[string]*entry
+	order   *list.List //keys of applied batches, oldest first
+	now     func() time.Time
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+}
+
+// NewWindow is factory method
+func NewWindow(ttl time.Duration, size int) *Window {
+	return &Window{
+		entries: make(map[string]*entry),
+		order:   list.New(),
+		now:     time.Now,
+		ttl:     ttl,
+		size:    size,
+	}
+}
+
+// Do calls f unless batch with key was applied within window, true is returned for such duplicate.
+// Duplicate arriving while batch is processed waits for result, failed batch isn't remembered, so its retry is applied
+func (w *Window) Do(key string, f func() error) (bool, error) {
+	for {
+		w.mu.Lock()
+		w.expire()
+		e, ok := w.entries[key]
+		if !ok {
+			e = &entry{done: make(chan struct{})}
+			w.entries[key] = e
+			w.mu.Unlock()
+			return false, w.apply(key, e, f)
+		}
+		w.mu.Unlock()
+		<-e.done
+		if e.ok {
+			return true, nil
+		}
+		//failed entry is removed by apply, next attempt processes batch
+	}
+}
+
+// apply processes batch and remembers it if f succeeds
+func (w *Window) apply(key string, e *entry, f func() error) error {
+	err := f()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		delete(w.entries, key)
+	} else {
+		e.ok = true
+		e.applied = w.now()
+		w.order.PushBack(key)
+		for w.size > 0 && w.order.Len() > w.size {
+			w.remove(w.order.Front())
+		}
+	}
+	close(e.done)
+	return err
+}
+
+// expire drops batches applied before ttl
+func (w *Window) expire() {
+	now := w.now()
+	for elem := w.order.Front(); elem != nil; elem = w.order.Front() {
+		e := w.entries[elem.Value.(string)]
+		if now.Sub(e.applied) < w.ttl {
+			return
+		}
+		w.remove(elem)
+	}
+}
+
+func (w *Window) remove(elem *list.Element) {
+	delete(w.entries, elem.Value.(string))
+	w.order.Remove(elem)
+}
+
+// Key returns window key of batch sent by tenant, so equal ids of different tenants don't collide
+func Key(tenantID string, id string) string {
+	return tenantID + "/" + id
+}
+
+// FromMetadata returns batch identifier from incoming gRPC metadata, empty string if it is not set
+func FromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(MetadataBatchID)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
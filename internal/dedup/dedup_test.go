@@ -0,0 +1,109 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWindow_Do(t *testing.T) {
+	clock := time.Now()
+	w := NewWindow(time.Minute, 2)
+	w.now = func() time.Time { return clock }
+	applied := 0
+	apply := func() error {
+		applied++
+		return nil
+	}
+	errApply := errors.New("storage error")
+
+	dup, err := w.Do(Key("", "1"), apply)
+	require.NoError(t, err)
+	require.False(t, dup)
+	//retry of applied batch
+	dup, err = w.Do(Key("", "1"), apply)
+	require.NoError(t, err)
+	require.True(t, dup)
+	require.Equal(t, 1, applied)
+	//equal id of other tenant
+	dup, err = w.Do(Key("team-a", "1"), apply)
+	require.NoError(t, err)
+	require.False(t, dup)
+	require.Equal(t, 2, applied)
+
+	//failed batch is applied on retry
+	_, err = w.Do(Key("", "2"), func() error { return errApply })
+	require.ErrorIs(t, err, errApply)
+	dup, err = w.Do(Key("", "2"), apply)
+	require.NoError(t, err)
+	require.False(t, dup)
+	require.Equal(t, 3, applied)
+
+	//oldest id is dropped when size is reached
+	dup, err = w.Do(Key("", "1"), apply)
+	require.NoError(t, err)
+	require.False(t, dup)
+	require.Equal(t, 4, applied)
+
+	//ids are dropped after ttl
+	clock = clock.Add(time.Minute)
+	dup, err = w.Do(Key("", "2"), apply)
+	require.NoError(t, err)
+	require.False(t, dup)
+	require.Equal(t, 5, applied)
+}
+
+func TestWindow_DoConcurrent(t *testing.T) {
+	w := NewWindow(time.Minute, 0)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var applied int
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := w.Do("1", func() error {
+			applied++
+			close(started)
+			<-release
+			return nil
+		})
+		assert.NoError(t, err)
+	}()
+	<-started
+	result := make(chan bool)
+	go func() {
+		dup, err := w.Do("1", func() error {
+			applied++
+			return nil
+		})
+		assert.NoError(t, err)
+		result <- dup
+	}()
+	close(release)
+	require.True(t, <-result)
+	wg.Wait()
+	require.Equal(t, 1, applied)
+}
+
+func TestFromMetadata(t *testing.T) {
+	require.Equal(t, "", FromMetadata(context.Background()))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataBatchID, "abc"))
+	require.Equal(t, "abc", FromMetadata(ctx))
+}
+
+func TestNewBatchID(t *testing.T) {
+	a, err := NewBatchID()
+	require.NoError(t, err)
+	b, err := NewBatchID()
+	require.NoError(t, err)
+	require.Len(t, a, 32)
+	require.NotEqual(t, a, b)
+	require.NoError(t, ValidateID(a))
+}
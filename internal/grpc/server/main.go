@@ -6,10 +6,11 @@ import (
 	"context"
 	"errors"
 
+	"github.com/esafronov/yp-metrics/internal/dedup"
 	pb "github.com/esafronov/yp-metrics/internal/grpc/proto"
 	"github.com/esafronov/yp-metrics/internal/logger"
-	"github.com/esafronov/yp-metrics/internal/pg"
 	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/esafronov/yp-metrics/internal/tenant"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -19,15 +20,17 @@ type MetricsServer struct {
 	// нужно встраивать тип pb.Unimplemented<TypeName>
 	// для совместимости с будущими версиями
 	pb.UnimplementedMetricsServer
-	Storage       storage.Repositories //repository
-	secretKey     string               //secret key for request signature validation
-	cryptoKey     string               //RSA private key for decrypting request
-	trustedSubnet string               //trusted subnet
+	Storage       storage.Repositories   //repository
+	secretKey     string                 //secret key for request signature validation
+	cryptoKey     string                 //RSA private key for decrypting request
+	trustedSubnet string                 //trusted subnet
+	names         *storage.NameValidator //rules of updated metric names
+	batches       *dedup.Window          //window of applied batch ids, batches are not deduplicated if nil
 }
 
 // NewMetricsServer is factory method
 func NewMetricsServer(s storage.Repositories, opts ...func(s *MetricsServer)) *MetricsServer {
-	h := &MetricsServer{Storage: s}
+	h := &MetricsServer{Storage: s, names: storage.NewNameValidator()}
 	for _, f := range opts {
 		f(h)
 	}
@@ -55,9 +58,23 @@ func OptionWithTrustedSubnet(trustedSubnet string) func(s *MetricsServer) {
 	}
 }
 
+// OptionWithNameValidator option function to configure MetricsServer to check metric names by validator rules
+func OptionWithNameValidator(v *storage.NameValidator) func(s *MetricsServer) {
+	return func(s *MetricsServer) {
+		s.names = v
+	}
+}
+
+// OptionWithDedup option function to configure MetricsServer to skip batches applied within window
+func OptionWithDedup(w *dedup.Window) func(s *MetricsServer) {
+	return func(s *MetricsServer) {
+		s.batches = w
+	}
+}
+
 func (s *MetricsServer) Ping(ctx context.Context, req *pb.PingRequest) (*pb.PingResponse, error) {
 	res := &pb.PingResponse{}
-	if err := pg.DB.PingContext(ctx); err != nil {
+	if err := s.Storage.Ping(ctx); err != nil {
 		return nil, status.Errorf(codes.Internal, err.Error())
 	}
 	return res, nil
@@ -138,6 +155,9 @@ func (s *MetricsServer) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.
 	if metricName == "" {
 		return nil, status.Errorf(codes.NotFound, "metric is not found")
 	}
+	if err := s.names.Validate(metricName); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	m, err := s.Storage.Get(ctx, metricName)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, err.Error())
@@ -155,7 +175,7 @@ func (s *MetricsServer) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.
 		err = s.Storage.Update(ctx, metricName, value, m)
 		if err != nil {
 			logger.Log.Error("update metric", zap.Error(err))
-			return nil, status.Errorf(codes.Internal, err.Error())
+			return nil, storageError(err)
 		}
 	} else {
 		switch req.Metric.Type {
@@ -171,7 +191,7 @@ func (s *MetricsServer) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.
 		err = s.Storage.Insert(ctx, metricName, m)
 		if err != nil {
 			logger.Log.Error("insert metric", zap.Error(err))
-			return nil, status.Errorf(codes.Internal, err.Error())
+			return nil, storageError(err)
 		}
 	}
 	res := &pb.UpdateResponse{
@@ -201,6 +221,9 @@ func (s *MetricsServer) BatchUpdate(ctx context.Context, req *pb.BatchUpdateRequ
 			logger.Log.Error(err.Error(), zap.Error(err))
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
+		if err := s.names.Validate(storage.MetricName(m.Id.Id)); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 		m := storage.Metrics{
 			ID:          m.Id.Id,
 			MType:       string(metricType),
@@ -208,9 +231,34 @@ func (s *MetricsServer) BatchUpdate(ctx context.Context, req *pb.BatchUpdateRequ
 		}
 		metrics = append(metrics, m)
 	}
-	err := s.Storage.BatchUpdate(context.Background(), metrics)
+	batchID := dedup.FromMetadata(ctx)
+	if err := dedup.ValidateID(batchID); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	update := func() error {
+		return s.Storage.BatchUpdate(ctx, metrics)
+	}
+	var err error
+	if s.batches != nil && batchID != "" {
+		//retried batch is acknowledged without applying it again
+		_, err = s.batches.Do(dedup.Key(string(tenant.FromContext(ctx)), batchID), update)
+	} else {
+		err = update()
+	}
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, err.Error())
+		return nil, storageError(err)
 	}
 	return &pb.BatchUpdateResponse{}, nil
 }
+
+// storageError converts error of storage write to gRPC status
+func storageError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, storage.ErrMetricNameReserved), errors.Is(err, storage.ErrMetricNameInvalid),
+		errors.Is(err, storage.ErrCardinalityExceeded):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
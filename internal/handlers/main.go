@@ -10,21 +10,28 @@ import (
 
 	"github.com/esafronov/yp-metrics/internal/access"
 	"github.com/esafronov/yp-metrics/internal/compress"
+	"github.com/esafronov/yp-metrics/internal/dedup"
 	"github.com/esafronov/yp-metrics/internal/encrypt"
+	"github.com/esafronov/yp-metrics/internal/limits"
 	"github.com/esafronov/yp-metrics/internal/logger"
-	"github.com/esafronov/yp-metrics/internal/pg"
 	"github.com/esafronov/yp-metrics/internal/signing"
 	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/esafronov/yp-metrics/internal/tenant"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
 
 // APIHandler have repository for storing metrics and secretKey for incomming request validation
 type APIHandler struct {
-	Storage       storage.Repositories //repository
-	secretKey     string               //secret key for request signature validation
-	cryptoKey     string               //RSA private key for decrypting request
-	trustedSubnet string               //trusted subnet
+	Storage       storage.Repositories   //repository
+	secretKey     string                 //secret key for request signature validation
+	cryptoKey     string                 //RSA private key for decrypting request
+	trustedSubnet string                 //trusted subnet
+	tenants       *tenant.Resolver       //resolver of request tenant, requests belong to default tenant if nil
+	limiter       *limits.Limiter        //ingestion limits of sources, updates are not limited if nil
+	names         *storage.NameValidator //rules of updated metric names
+	batches       *dedup.Window          //window of applied batch ids, batches are not deduplicated if nil
+	dumpAPI       bool                   //serve export and import of all metrics
 }
 
 // OptionWithSecretKey option function to configure APIHandler to use secretKey
@@ -47,9 +54,44 @@ func OptionWithTrustedSubnet(trustedSubnet string) func(h *APIHandler) {
 	}
 }
 
+// OptionWithTenantResolver option function to configure APIHandler to isolate metrics of request tenants
+func OptionWithTenantResolver(r *tenant.Resolver) func(h *APIHandler) {
+	return func(h *APIHandler) {
+		h.tenants = r
+	}
+}
+
+// OptionWithNameValidator option function to configure APIHandler to check metric names by validator rules
+func OptionWithNameValidator(v *storage.NameValidator) func(h *APIHandler) {
+	return func(h *APIHandler) {
+		h.names = v
+	}
+}
+
+// OptionWithLimiter option function to configure APIHandler to limit updates of sources
+func OptionWithLimiter(l *limits.Limiter) func(h *APIHandler) {
+	return func(h *APIHandler) {
+		h.limiter = l
+	}
+}
+
+// OptionWithDedup option function to configure APIHandler to skip batches applied within window
+func OptionWithDedup(w *dedup.Window) func(h *APIHandler) {
+	return func(h *APIHandler) {
+		h.batches = w
+	}
+}
+
+// OptionWithDumpAPI option function to configure APIHandler to serve export and import of all metrics
+func OptionWithDumpAPI(enabled bool) func(h *APIHandler) {
+	return func(h *APIHandler) {
+		h.dumpAPI = enabled
+	}
+}
+
 // NewAPIHandler is factory method
 func NewAPIHandler(s storage.Repositories, opts ...func(h *APIHandler)) *APIHandler {
-	h := &APIHandler{Storage: s}
+	h := &APIHandler{Storage: s, names: storage.NewNameValidator()}
 	for _, f := range opts {
 		f(h)
 	}
@@ -62,9 +104,15 @@ func (h APIHandler) GetRouter() chi.Router {
 	r.Use(logger.RequestLogger)
 	r.Use(access.ValidateIp(h.trustedSubnet))
 	r.Use(compress.GzipCompressing)
+	if h.tenants != nil {
+		r.Use(tenant.Middleware(h.tenants))
+	}
 	r.Get("/", h.Index)    //html table with all stored metrics
-	r.Get("/ping", h.Ping) //test DB connection
+	r.Get("/ping", h.Ping) //test storage backend
 	r.Route("/update", func(r chi.Router) {
+		if h.limiter != nil {
+			r.Use(limits.Middleware(h.limiter))
+		}
 		r.Group(func(r chi.Router) {
 			r.Use(encrypt.DecryptingMiddleware(h.cryptoKey)) //decrypt body with RSA algo
 			r.Post("/", h.UpdateJSON)                        //update metric with json request
@@ -76,15 +124,29 @@ func (h APIHandler) GetRouter() chi.Router {
 		r.Get("/{type}/{name}", h.Value) //get metric value with url request
 	})
 	r.Route("/updates", func(r chi.Router) {
+		if h.limiter != nil {
+			r.Use(limits.Middleware(h.limiter))
+		}
 		r.Use(signing.ValidateSignature(h.secretKey))
 		r.Post("/", h.Updates) //batch updating
 	})
+	if !h.dumpAPI {
+		return r
+	}
+	r.Get("/export", h.Export) //dump of all metrics in JSON lines
+	r.Route("/import", func(r chi.Router) {
+		if h.limiter != nil {
+			r.Use(limits.Middleware(h.limiter))
+		}
+		r.Use(signing.ValidateSignature(h.secretKey))
+		r.Post("/", h.Import) //restore metrics from dump
+	})
 	return r
 }
 
 // Ping handler for testing service
 func (h APIHandler) Ping(res http.ResponseWriter, req *http.Request) {
-	if err := pg.DB.PingContext(req.Context()); err != nil {
+	if err := h.Storage.Ping(req.Context()); err != nil {
 		logger.Log.Info("ping error", zap.Error(err))
 		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
@@ -169,6 +231,18 @@ func (h APIHandler) UpdateJSON(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 	metricName := storage.MetricName(reqMetric.ID)
+	if err := h.names.Validate(metricName); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	done, ok := h.checkBatch(res, req, []string{reqMetric.ID})
+	if !ok {
+		return
+	}
+	applied := false
+	defer func() {
+		done(applied)
+	}()
 	value := reqMetric.ActualValue
 	metric, err := h.Storage.Get(req.Context(), metricName)
 	if err != nil {
@@ -180,7 +254,7 @@ func (h APIHandler) UpdateJSON(res http.ResponseWriter, req *http.Request) {
 		err = h.Storage.Update(req.Context(), metricName, value, metric)
 		if err != nil {
 			logger.Log.Error("update metric", zap.Error(err))
-			http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeStorageError(res, err)
 			return
 		}
 	} else {
@@ -197,10 +271,11 @@ func (h APIHandler) UpdateJSON(res http.ResponseWriter, req *http.Request) {
 		err = h.Storage.Insert(req.Context(), metricName, metric)
 		if err != nil {
 			logger.Log.Error("insert metric", zap.Error(err))
-			http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeStorageError(res, err)
 			return
 		}
 	}
+	applied = true
 	if metric == nil {
 		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
@@ -250,6 +325,18 @@ func (h APIHandler) Update(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 	}
+	if err := h.names.Validate(metricName); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	done, ok := h.checkBatch(res, req, []string{mn})
+	if !ok {
+		return
+	}
+	applied := false
+	defer func() {
+		done(applied)
+	}()
 	metric, err := h.Storage.Get(req.Context(), metricName)
 	if err != nil {
 		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -258,7 +345,7 @@ func (h APIHandler) Update(res http.ResponseWriter, req *http.Request) {
 	if metric != nil {
 		err := h.Storage.Update(req.Context(), metricName, value, metric)
 		if err != nil {
-			http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeStorageError(res, err)
 			return
 		}
 	} else {
@@ -266,17 +353,18 @@ func (h APIHandler) Update(res http.ResponseWriter, req *http.Request) {
 		case storage.MetricTypeGauge:
 			err := h.Storage.Insert(req.Context(), metricName, storage.NewMetricGauge(value))
 			if err != nil {
-				http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				writeStorageError(res, err)
 				return
 			}
 		case storage.MetricTypeCounter:
 			err := h.Storage.Insert(req.Context(), metricName, storage.NewMetricCounter(value))
 			if err != nil {
-				http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				writeStorageError(res, err)
 				return
 			}
 		}
 	}
+	applied = true
 	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	res.WriteHeader(http.StatusOK)
 }
@@ -305,6 +393,64 @@ func (h APIHandler) Value(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Export handler responds with current values of all metrics in JSON lines, one metric per line
+func (h APIHandler) Export(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/x-ndjson")
+	if _, err := storage.Export(req.Context(), h.Storage, res); err != nil {
+		logger.Log.Error("export metrics", zap.Error(err))
+		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Import handler restores metrics from dump made by Export, counters get values of dump
+func (h APIHandler) Import(res http.ResponseWriter, req *http.Request) {
+	imported, err := storage.Import(req.Context(), h.Storage, req.Body, h.names)
+	if err != nil {
+		logger.Log.Error("import metrics", zap.Int("imported", imported), zap.Error(err))
+		if errors.Is(err, storage.ErrDumpInvalid) || errors.Is(err, storage.ErrMetricNameInvalid) {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeStorageError(res, err)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(res).Encode(struct {
+		Imported int `json:"imported"`
+	}{imported}); err != nil {
+		logger.Log.Info(err.Error())
+	}
+}
+
+// checkBatch checks limits of metrics updated by request, false if response with error is written.
+// Returned done must be called with result of update
+func (h APIHandler) checkBatch(res http.ResponseWriter, req *http.Request, names []string) (done func(applied bool), ok bool) {
+	if h.limiter == nil {
+		return func(bool) {}, true
+	}
+	done, err := h.limiter.CheckBatch(limits.FromContext(req.Context()), names)
+	if err != nil {
+		limits.WriteError(res, err)
+		return nil, false
+	}
+	return done, true
+}
+
+// writeStorageError responds with status matching error of storage write
+func writeStorageError(res http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		http.Error(res, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, storage.ErrMetricNameReserved), errors.Is(err, storage.ErrMetricNameInvalid),
+		errors.Is(err, storage.ErrCardinalityExceeded):
+		http.Error(res, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
 var ErrMetricType = errors.New("metric type is wrong")
 var ErrMetricName = errors.New("metric name is empty")
 
@@ -350,11 +496,42 @@ func (h APIHandler) Updates(res http.ResponseWriter, req *http.Request) {
 		}
 		return
 	}
-	if err := h.Storage.BatchUpdate(req.Context(), metrics); err != nil {
+	names := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		if err := h.names.Validate(storage.MetricName(m.ID)); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		names = append(names, m.ID)
+	}
+	done, ok := h.checkBatch(res, req, names)
+	if !ok {
+		return
+	}
+	applied := false
+	defer func() {
+		done(applied)
+	}()
+	batchID := req.Header.Get(dedup.HeaderBatchID)
+	if err := dedup.ValidateID(batchID); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	update := func() error {
+		return h.Storage.BatchUpdate(req.Context(), metrics)
+	}
+	if h.batches != nil && batchID != "" {
+		//retried batch is acknowledged without applying it again
+		_, err = h.batches.Do(dedup.Key(string(tenant.FromContext(req.Context())), batchID), update)
+	} else {
+		err = update()
+	}
+	if err != nil {
 		logger.Log.Error("batch metrics update", zap.Error(err))
-		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStorageError(res, err)
 		return
 	}
+	applied = true
 	res.Header().Set("Content-Type", "application/json")
 	res.WriteHeader(http.StatusOK)
 }
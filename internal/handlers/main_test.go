@@ -9,11 +9,14 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/esafronov/yp-metrics/internal/pg"
+	"github.com/esafronov/yp-metrics/internal/dedup"
+	"github.com/esafronov/yp-metrics/internal/limits"
 	"github.com/esafronov/yp-metrics/internal/signing"
 	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/esafronov/yp-metrics/internal/tenant"
+	"github.com/pashagolub/pgxmock/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -75,11 +78,9 @@ func TestAPIHandler_Update(t *testing.T) {
 	}{
 		{
 			name: "positive update gauge sequence",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{
-					"test": storage.NewMetricGauge(float64(1.2)),
-				},
-			},
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+				"test": storage.NewMetricGauge(float64(1.2)),
+			}),
 			request: &request{
 				path: "/update/gauge/test/1.1",
 			},
@@ -91,11 +92,9 @@ func TestAPIHandler_Update(t *testing.T) {
 		},
 		{
 			name: "positive update counter sequence",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{
-					"test": storage.NewMetricCounter(int64(2)),
-				},
-			},
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+				"test": storage.NewMetricCounter(int64(2)),
+			}),
 			request: &request{
 				path: "/update/counter/test/2",
 			},
@@ -107,10 +106,8 @@ func TestAPIHandler_Update(t *testing.T) {
 			},
 		},
 		{
-			name: "positive new gauge",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "positive new gauge",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/gauge/test/1.1",
 			},
@@ -122,10 +119,8 @@ func TestAPIHandler_Update(t *testing.T) {
 			},
 		},
 		{
-			name: "positive new counter",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "positive new counter",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/counter/test/2",
 			},
@@ -137,10 +132,8 @@ func TestAPIHandler_Update(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong gauge value",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong gauge value",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/gauge/test/f",
 			},
@@ -149,10 +142,8 @@ func TestAPIHandler_Update(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong counter value",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong counter value",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/counter/test/1.1",
 			},
@@ -161,10 +152,8 @@ func TestAPIHandler_Update(t *testing.T) {
 			},
 		},
 		{
-			name: "empty metric name",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "empty metric name",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/counter//1",
 			},
@@ -173,10 +162,8 @@ func TestAPIHandler_Update(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong metric type",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong metric type",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update//test/1",
 			},
@@ -185,10 +172,8 @@ func TestAPIHandler_Update(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong path #1",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong path #1",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/updat",
 			},
@@ -253,11 +238,9 @@ func TestAPIHandler_UpdateJSON(t *testing.T) {
 	}{
 		{
 			name: "positive update gauge sequence",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{
-					"test": storage.NewMetricGauge(float64(1.2)),
-				},
-			},
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+				"test": storage.NewMetricGauge(float64(1.2)),
+			}),
 			request: &request{
 				path: "/update/",
 				body: `{
@@ -280,11 +263,9 @@ func TestAPIHandler_UpdateJSON(t *testing.T) {
 		},
 		{
 			name: "positive update counter sequence",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{
-					"test": storage.NewMetricCounter(int64(2)),
-				},
-			},
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+				"test": storage.NewMetricCounter(int64(2)),
+			}),
 			request: &request{
 				path: "/update/",
 				body: `{
@@ -306,10 +287,8 @@ func TestAPIHandler_UpdateJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "positive new gauge",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "positive new gauge",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/",
 				body: `{
@@ -331,10 +310,8 @@ func TestAPIHandler_UpdateJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "positive new counter",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "positive new counter",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/",
 				body: `{
@@ -356,10 +333,8 @@ func TestAPIHandler_UpdateJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong gauge value",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong gauge value",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/",
 				body: `{
@@ -373,10 +348,8 @@ func TestAPIHandler_UpdateJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong counter value",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong counter value",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/",
 				body: `{
@@ -390,10 +363,8 @@ func TestAPIHandler_UpdateJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "empty metric name",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "empty metric name",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/",
 				body: `{
@@ -407,10 +378,8 @@ func TestAPIHandler_UpdateJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong metric type",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong metric type",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/",
 				body: `{
@@ -424,10 +393,8 @@ func TestAPIHandler_UpdateJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong path #1",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong path #1",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/updat",
 				body: `{}`,
@@ -437,10 +404,8 @@ func TestAPIHandler_UpdateJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong content type",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong content type",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				contentType: "text/html",
 				path:        "/update/",
@@ -516,10 +481,8 @@ func TestAPIHandler_Updates(t *testing.T) {
 		want    want
 	}{
 		{
-			name: "batch positive signature",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "batch positive signature",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/updates/",
 				body: `[{
@@ -538,10 +501,8 @@ func TestAPIHandler_Updates(t *testing.T) {
 			},
 		},
 		{
-			name: "batch wrong signature",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "batch wrong signature",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/updates/",
 				body: `[{
@@ -557,10 +518,8 @@ func TestAPIHandler_Updates(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong media content type",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong media content type",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/updates/",
 				body: `[{
@@ -577,10 +536,8 @@ func TestAPIHandler_Updates(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong metric type",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong metric type",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/updates/",
 				body: `[{
@@ -597,10 +554,8 @@ func TestAPIHandler_Updates(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong metric name",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong metric name",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/updates/",
 				body: `[{
@@ -929,11 +884,9 @@ func ExampleAPIHandler_ValueJSON() {
 
 func TestAPIHandler_Index(t *testing.T) {
 
-	s := &storage.MemStorage{
-		Values: map[storage.MetricName]storage.Metric{
-			"test": storage.NewMetricGauge(float64(1.2)),
-		},
-	}
+	s := storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+		"test": storage.NewMetricGauge(float64(1.2)),
+	})
 
 	h := NewAPIHandler(s)
 	ts := httptest.NewServer(h.GetRouter())
@@ -998,11 +951,9 @@ func TestAPIHandler_ValueJSON(t *testing.T) {
 	}{
 		{
 			name: "positive get gauge metric",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{
-					"test": storage.NewMetricGauge(float64(1.2)),
-				},
-			},
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+				"test": storage.NewMetricGauge(float64(1.2)),
+			}),
 			request: &request{
 				path: "/value/",
 				body: `{
@@ -1024,11 +975,9 @@ func TestAPIHandler_ValueJSON(t *testing.T) {
 		},
 		{
 			name: "positive get counter metric",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{
-					"test": storage.NewMetricCounter(int64(2)),
-				},
-			},
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+				"test": storage.NewMetricCounter(int64(2)),
+			}),
 			request: &request{
 				path: "/value/",
 				body: `{
@@ -1049,10 +998,8 @@ func TestAPIHandler_ValueJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "metric not found by name",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "metric not found by name",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/value/",
 				body: `{
@@ -1065,10 +1012,8 @@ func TestAPIHandler_ValueJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong metric type",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong metric type",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/value/",
 				body: `{
@@ -1081,10 +1026,8 @@ func TestAPIHandler_ValueJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "empty metric name",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "empty metric name",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/",
 				body: `{
@@ -1097,10 +1040,8 @@ func TestAPIHandler_ValueJSON(t *testing.T) {
 			},
 		},
 		{
-			name: "wrong json request",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "wrong json request",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/update/",
 				body: `{
@@ -1115,9 +1056,7 @@ func TestAPIHandler_ValueJSON(t *testing.T) {
 		{
 			name: "wrong request content type",
 
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				contentType: "text/html",
 				path:        "/update/",
@@ -1169,14 +1108,19 @@ func TestAPIHandler_ValueJSON(t *testing.T) {
 }
 
 func TestAPIHandler_Ping(t *testing.T) {
-	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	mock, err := pgxmock.NewPool()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
+	//migrations are applied on storage creation
+	mock.ExpectBegin()
+	mock.ExpectExec("^SELECT pg_advisory_xact_lock").WithArgs(pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mock.ExpectExec("^CREATE TABLE IF NOT EXISTS").WithArgs().WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+	mock.ExpectQuery("^SELECT version").WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(int64(1)).AddRow(int64(2)))
+	mock.ExpectCommit()
 	mock.ExpectPing()
-	s := &storage.DBStorage{}
+	s, err := storage.NewDBStorage(context.Background(), mock)
 	require.NoError(t, err)
-	pg.DB = db
 	h := NewAPIHandler(s)
 	ts := httptest.NewServer(h.GetRouter())
 	defer ts.Close()
@@ -1220,11 +1164,9 @@ func TestAPIHandler_Value(t *testing.T) {
 	}{
 		{
 			name: "positive view gauge value",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{
-					"test": storage.NewMetricGauge(float64(1.2)),
-				},
-			},
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+				"test": storage.NewMetricGauge(float64(1.2)),
+			}),
 			request: &request{
 				path: "/value/gauge/test",
 			},
@@ -1238,11 +1180,9 @@ func TestAPIHandler_Value(t *testing.T) {
 		},
 		{
 			name: "positive view counter value",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{
-					"test": storage.NewMetricCounter(int64(2)),
-				},
-			},
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+				"test": storage.NewMetricCounter(int64(2)),
+			}),
 			request: &request{
 				path: "/value/counter/test",
 			},
@@ -1255,10 +1195,8 @@ func TestAPIHandler_Value(t *testing.T) {
 			},
 		},
 		{
-			name: "empty metric name",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "empty metric name",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/value/counter/",
 			},
@@ -1267,10 +1205,8 @@ func TestAPIHandler_Value(t *testing.T) {
 			},
 		},
 		{
-			name: "metric not found",
-			storage: &storage.MemStorage{
-				Values: map[storage.MetricName]storage.Metric{},
-			},
+			name:    "metric not found",
+			storage: storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{}),
 			request: &request{
 				path: "/value/counter/test",
 			},
@@ -1309,3 +1245,215 @@ func TestAPIHandler_Value(t *testing.T) {
 		})
 	}
 }
+
+func TestAPIHandler_Tenants(t *testing.T) {
+	mem := storage.NewMemStorage()
+	s := storage.NewTenantStorage(mem, storage.OptionWithTenantQuota(1))
+	h := NewAPIHandler(s, OptionWithTenantResolver(tenant.NewResolver()))
+	ts := httptest.NewServer(h.GetRouter())
+	defer ts.Close()
+
+	update := func(tenantID string, url string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+url, nil)
+		require.NoError(t, err)
+		req.Header.Set(tenant.HeaderTenantID, tenantID)
+		res, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res.StatusCode
+	}
+	require.Equal(t, http.StatusOK, update("team-a", "/update/counter/test/1"))
+	require.Equal(t, http.StatusOK, update("team-b", "/update/counter/test/2"))
+	require.Equal(t, http.StatusTooManyRequests, update("team-a", "/update/counter/other/1"))
+	require.Equal(t, http.StatusBadRequest, update("team a", "/update/counter/test/1"))
+
+	m, err := s.Get(tenant.NewContext(context.Background(), "team-b"), "test")
+	require.NoError(t, err)
+	require.Equal(t, storage.NewMetricCounter(int64(2)), m)
+	m, err = mem.Get(context.Background(), "team-a/test")
+	require.NoError(t, err)
+	require.Equal(t, storage.NewMetricCounter(int64(1)), m)
+}
+
+func TestAPIHandler_Limits(t *testing.T) {
+	s := storage.NewMemStorage()
+	l := limits.NewLimiter(limits.OptionWithMaxBatch(2), limits.OptionWithMaxNames(2))
+	h := NewAPIHandler(s, OptionWithLimiter(l))
+	ts := httptest.NewServer(h.GetRouter())
+	defer ts.Close()
+
+	updates := func(body string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		res, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res.StatusCode
+	}
+	require.Equal(t, http.StatusRequestEntityTooLarge, updates(`[{"id":"a","type":"counter","delta":1},{"id":"b","type":"counter","delta":1},{"id":"c","type":"counter","delta":1}]`))
+	require.Equal(t, http.StatusOK, updates(`[{"id":"a","type":"counter","delta":1},{"id":"b","type":"counter","delta":1}]`))
+	require.Equal(t, http.StatusTooManyRequests, updates(`[{"id":"c","type":"counter","delta":1}]`))
+
+	m, err := s.Get(context.Background(), "c")
+	require.NoError(t, err)
+	require.Nil(t, m)
+}
+
+func TestAPIHandler_LimitsFailedUpdate(t *testing.T) {
+	s := storage.NewCardinalityStorage(storage.NewMemStorage(), 1)
+	l := limits.NewLimiter(limits.OptionWithMaxNames(2))
+	h := NewAPIHandler(s, OptionWithLimiter(l))
+	ts := httptest.NewServer(h.GetRouter())
+	defer ts.Close()
+
+	updates := func(body string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		res, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res.StatusCode
+	}
+	require.Equal(t, http.StatusBadRequest, updates(`[{"id":"a","type":"counter","delta":1},{"id":"b","type":"counter","delta":1}]`))
+	//names of rejected update are not counted
+	require.Equal(t, http.StatusOK, updates(`[{"id":"c","type":"counter","delta":1}]`))
+}
+
+func TestAPIHandler_MetricNames(t *testing.T) {
+	s := storage.NewCardinalityStorage(storage.NewMemStorage(), 1)
+	h := NewAPIHandler(s, OptionWithNameValidator(storage.NewNameValidator(storage.OptionWithReservedPrefixes("server."))))
+	ts := httptest.NewServer(h.GetRouter())
+	defer ts.Close()
+
+	post := func(url string, body string) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		res, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, res.Body.Close())
+		}()
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(b)
+	}
+	long := strings.Repeat("a", storage.MaxMetricNameLength+1)
+	code, body := post("/update/counter/"+long+"/1", "")
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, "longer than 255 characters")
+	code, body = post("/update/", `{"id":"server.uptime","type":"gauge","value":1}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, "reserved prefix")
+	code, _ = post("/updates/", `[{"id":"ok","type":"counter","delta":1},{"id":"not ok","type":"counter","delta":1}]`)
+	require.Equal(t, http.StatusBadRequest, code)
+	//max number of metrics
+	code, _ = post("/update/counter/first/1", "")
+	require.Equal(t, http.StatusOK, code)
+	code, body = post("/update/counter/second/1", "")
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, "max number of metrics")
+}
+
+func TestAPIHandler_ExportImport(t *testing.T) {
+	src := storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+		"counter": storage.NewMetricCounter(int64(10)),
+		"gauge":   storage.NewMetricGauge(float64(0.5)),
+	})
+	srcServer := httptest.NewServer(NewAPIHandler(src, OptionWithDumpAPI(true)).GetRouter())
+	defer srcServer.Close()
+	dst := storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+		"counter": storage.NewMetricCounter(int64(3)),
+	})
+	dstServer := httptest.NewServer(NewAPIHandler(dst, OptionWithDumpAPI(true), OptionWithLimiter(limits.NewLimiter(limits.OptionWithMaxBody(1024)))).GetRouter())
+	defer dstServer.Close()
+
+	res, err := srcServer.Client().Get(srcServer.URL + "/export")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+	dump, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+
+	res, err = dstServer.Client().Post(dstServer.URL+"/import/", "application/x-ndjson", strings.NewReader(string(dump)))
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.JSONEq(t, `{"imported":2}`, string(body))
+
+	all, err := dst.GetAll(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[storage.MetricName]storage.Metric{
+		"counter": storage.NewMetricCounter(int64(10)),
+		"gauge":   storage.NewMetricGauge(float64(0.5)),
+	}, all)
+
+	res, err = dstServer.Client().Post(dstServer.URL+"/import/", "application/x-ndjson", strings.NewReader("not json"))
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	//body size of import is limited
+	res, err = dstServer.Client().Post(dstServer.URL+"/import/", "application/x-ndjson", strings.NewReader(strings.Repeat(string(dump), 100)))
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
+
+func TestAPIHandler_DumpAPIDisabled(t *testing.T) {
+	s := storage.NewMemStorageWithValues(map[storage.MetricName]storage.Metric{
+		"counter": storage.NewMetricCounter(int64(10)),
+	})
+	ts := httptest.NewServer(NewAPIHandler(s).GetRouter())
+	defer ts.Close()
+
+	res, err := ts.Client().Get(ts.URL + "/export")
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, err = ts.Client().Post(ts.URL+"/import/", "application/x-ndjson", strings.NewReader(`{"id":"counter","type":"counter","delta":1}`))
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	m, err := s.Get(context.Background(), "counter")
+	require.NoError(t, err)
+	require.Equal(t, storage.NewMetricCounter(int64(10)), m)
+}
+
+func TestAPIHandler_BatchDedup(t *testing.T) {
+	s := storage.NewMemStorage()
+	h := NewAPIHandler(s, OptionWithDedup(dedup.NewWindow(time.Minute, 100)))
+	ts := httptest.NewServer(h.GetRouter())
+	defer ts.Close()
+
+	updates := func(batchID string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(`[{"id":"test","type":"counter","delta":1}]`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if batchID != "" {
+			req.Header.Set(dedup.HeaderBatchID, batchID)
+		}
+		res, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res.StatusCode
+	}
+	//retried batch is acknowledged but not applied
+	require.Equal(t, http.StatusOK, updates("batch-1"))
+	require.Equal(t, http.StatusOK, updates("batch-1"))
+	require.Equal(t, http.StatusOK, updates("batch-2"))
+	//batches without id are always applied
+	require.Equal(t, http.StatusOK, updates(""))
+	require.Equal(t, http.StatusBadRequest, updates(strings.Repeat("a", 129)))
+
+	m, err := s.Get(context.Background(), "test")
+	require.NoError(t, err)
+	require.Equal(t, storage.NewMetricCounter(int64(3)), m)
+}
@@ -0,0 +1,266 @@
+// Package limits implements ingestion limits of metric sources: request rate,
+// metrics per batch, distinct metric names and request body size.
+// Source is tenant of request if it is set, otherwise address of agent
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRateLimited    = errors.New("request rate limit is exceeded")
+	ErrBatchTooLarge  = errors.New("too many metrics in batch")
+	ErrTooManyNames   = errors.New("too many distinct metrics of source")
+	ErrTooManySources = errors.New("too many metric sources")
+	ErrBodyTooLarge   = errors.New("request body is too large")
+)
+
+// sweepInterval how often buckets of idle sources are dropped
+const sweepInterval time.Duration = time.Minute
+
+// namesIdleTimeout names of source are forgotten if it doesn't send updates for this time
+const namesIdleTimeout time.Duration = time.Hour
+
+// maxSources max sources with remembered names, updates of new sources are rejected above it
+const maxSources int = 100000
+
+// RateError error of exceeded request rate with time after which request may be retried
+type RateError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrRateLimited, e.RetryAfter)
+}
+
+func (e *RateError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// bucket token bucket of source
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// sourceNames distinct metric names of source
+type sourceNames struct {
+	committed map[string]bool //names written by source
+	pending   map[string]int  //not committed names reserved by requests in progress
+	seen      time.Time       //last update of source
+}
+
+// count returns number of committed and reserved names
+func (s *sourceNames) count() int {
+	n := len(s.committed)
+	for name := range s.pending {
+		if !s.committed[name] {
+			n++
+		}
+	}
+	return n
+}
+
+// has checks name is committed or reserved
+func (s *sourceNames) has(name string) bool {
+	return s.committed[name] || s.pending[name] > 0
+}
+
+// Limiter enforces limits per source, zero value of limit means it is not limited
+type Limiter struct {
+	buckets   map[string]*bucket
+	names     map[string]*sourceNames
+	now       func() time.Time
+	lastSweep time.Time
+	trusted   *net.IPNet //subnet of proxies setting address of agent in X-Real-IP
+	mu        sync.Mutex
+	rate      float64 //requests per second
+	burst     int     //max requests in burst
+	maxBatch  int     //max metrics per request
+	maxNames  int     //max distinct metric names per source
+	maxBody   int64   //max request body size in bytes
+}
+
+// OptionWithRate option function to configure requests per second of source and burst size, burst is at least 1
+func OptionWithRate(rate float64, burst int) func(l *Limiter) {
+	return func(l *Limiter) {
+		l.rate = rate
+		l.burst = max(burst, 1)
+	}
+}
+
+// OptionWithMaxBatch option function to configure max metrics per request
+func OptionWithMaxBatch(n int) func(l *Limiter) {
+	return func(l *Limiter) {
+		l.maxBatch = n
+	}
+}
+
+// OptionWithMaxNames option function to configure max distinct metric names per source
+func OptionWithMaxNames(n int) func(l *Limiter) {
+	return func(l *Limiter) {
+		l.maxNames = n
+	}
+}
+
+// OptionWithMaxBody option function to configure max request body size in bytes
+func OptionWithMaxBody(n int64) func(l *Limiter) {
+	return func(l *Limiter) {
+		l.maxBody = n
+	}
+}
+
+// OptionWithTrustedSubnet option function to configure subnet of proxies, address of agent is taken from
+// X-Real-IP header only in requests from this subnet, invalid subnet is not trusted
+func OptionWithTrustedSubnet(subnet string) func(l *Limiter) {
+	return func(l *Limiter) {
+		if subnet == "" {
+			return
+		}
+		if _, ipNet, err := net.ParseCIDR(subnet); err == nil {
+			l.trusted = ipNet
+		}
+	}
+}
+
+// NewLimiter is factory method
+func NewLimiter(opts ...func(l *Limiter)) *Limiter {
+	l := &Limiter{
+		buckets: make(map[string]*bucket),
+		names:   make(map[string]*sourceNames),
+		now:     time.Now,
+		burst:   1,
+	}
+	for _, f := range opts {
+		f(l)
+	}
+	return l
+}
+
+// MaxBody returns max request body size in bytes, 0 = not limited
+func (l *Limiter) MaxBody() int64 {
+	return l.maxBody
+}
+
+// Allow takes token of source, RateError is returned if there are no tokens
+func (l *Limiter) Allow(source string) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[source]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), updated: now}
+		l.buckets[source] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updated = now
+	if b.tokens < 1 {
+		wait := time.Duration(math.Ceil((1 - b.tokens) / l.rate * float64(time.Second)))
+		return &RateError{RetryAfter: wait}
+	}
+	b.tokens--
+	return nil
+}
+
+// CheckBatch checks number of metrics in request of source and reserves their names,
+// request is rejected as a whole if its new names don't fit limit of source.
+// Returned done must be called when request is finished, names are remembered only if they are applied
+func (l *Limiter) CheckBatch(source string, names []string) (done func(applied bool), err error) {
+	if l.maxBatch > 0 && len(names) > l.maxBatch {
+		return nil, fmt.Errorf("%w: %d, max %d", ErrBatchTooLarge, len(names), l.maxBatch)
+	}
+	if l.maxNames <= 0 {
+		return func(bool) {}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	known, ok := l.names[source]
+	if !ok {
+		if len(l.names) >= maxSources {
+			return nil, fmt.Errorf("%w: max %d", ErrTooManySources, maxSources)
+		}
+		known = &sourceNames{
+			committed: make(map[string]bool),
+			pending:   make(map[string]int),
+		}
+		l.names[source] = known
+	}
+	known.seen = now
+	added := make(map[string]bool)
+	for _, name := range names {
+		if !known.has(name) {
+			added[name] = true
+		}
+	}
+	if known.count()+len(added) > l.maxNames {
+		return nil, fmt.Errorf("%w: max %d", ErrTooManyNames, l.maxNames)
+	}
+	//names already committed need no reservation
+	reserved := make(map[string]bool)
+	for _, name := range names {
+		if !known.committed[name] && !reserved[name] {
+			reserved[name] = true
+			known.pending[name]++
+		}
+	}
+	return func(applied bool) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for name := range reserved {
+			if known.pending[name]--; known.pending[name] <= 0 {
+				delete(known.pending, name)
+			}
+			if applied {
+				known.committed[name] = true
+			}
+		}
+	}, nil
+}
+
+// refill returns tokens of bucket at the moment
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	return min(float64(l.burst), b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+}
+
+// sweep drops full buckets and names of idle sources, sources without bucket start with full one
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for source, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.burst) {
+			delete(l.buckets, source)
+		}
+	}
+	for source, known := range l.names {
+		if len(known.pending) == 0 && now.Sub(known.seen) >= namesIdleTimeout {
+			delete(l.names, source)
+		}
+	}
+}
+
+type ctxKey struct{}
+
+// NewContext returns context carrying source of request
+func NewContext(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, source)
+}
+
+// FromContext returns source of request
+func FromContext(ctx context.Context) string {
+	source, _ := ctx.Value(ctxKey{}).(string)
+	return source
+}
@@ -0,0 +1,207 @@
+package limits
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/esafronov/yp-metrics/internal/grpc/proto"
+	"github.com/esafronov/yp-metrics/internal/tenant"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClock time source moved by test
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newLimiter(clock *fakeClock, opts ...func(l *Limiter)) *Limiter {
+	l := NewLimiter(opts...)
+	l.now = clock.now
+	return l
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := newLimiter(clock, OptionWithRate(2, 3))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Allow("agent"), "request %d of burst", i)
+	}
+	err := l.Allow("agent")
+	require.ErrorIs(t, err, ErrRateLimited)
+	var rateErr *RateError
+	require.ErrorAs(t, err, &rateErr)
+	require.Equal(t, 500*time.Millisecond, rateErr.RetryAfter)
+
+	//other sources have own bucket
+	require.NoError(t, l.Allow("other"))
+
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	require.NoError(t, l.Allow("agent"))
+	require.ErrorIs(t, l.Allow("agent"), ErrRateLimited)
+
+	//idle buckets are dropped
+	clock.t = clock.t.Add(sweepInterval)
+	require.NoError(t, l.Allow("agent"))
+	require.Len(t, l.buckets, 1)
+
+	//rate is not limited by default
+	l = newLimiter(clock)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, l.Allow("agent"))
+	}
+}
+
+func TestLimiter_CheckBatch(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := newLimiter(clock, OptionWithMaxBatch(3), OptionWithMaxNames(4))
+	check := func(source string, names ...string) error {
+		done, err := l.CheckBatch(source, names)
+		if err == nil {
+			done(true)
+		}
+		return err
+	}
+
+	require.ErrorIs(t, check("agent", "a", "b", "c", "d"), ErrBatchTooLarge)
+	require.NoError(t, check("agent", "a", "b", "c"))
+	require.NoError(t, check("agent", "a", "b", "c"))
+	//batch is rejected as a whole
+	require.ErrorIs(t, check("agent", "a", "d", "e"), ErrTooManyNames)
+	require.NoError(t, check("agent", "d"))
+	require.ErrorIs(t, check("agent", "e"), ErrTooManyNames)
+	//names are counted per source
+	require.NoError(t, check("other", "e"))
+
+	//names of idle sources are forgotten
+	clock.t = clock.t.Add(namesIdleTimeout)
+	require.NoError(t, check("other", "f"))
+	require.Len(t, l.names, 1)
+	require.NoError(t, check("agent", "e"))
+}
+
+func TestLimiter_CheckBatchNotApplied(t *testing.T) {
+	l := NewLimiter(OptionWithMaxNames(2))
+
+	//names of request in progress are reserved
+	done, err := l.CheckBatch("agent", []string{"a", "b"})
+	require.NoError(t, err)
+	_, err = l.CheckBatch("agent", []string{"c"})
+	require.ErrorIs(t, err, ErrTooManyNames)
+	doneOther, err := l.CheckBatch("agent", []string{"a"})
+	require.NoError(t, err)
+
+	//names of failed write are released, name reserved by other request is kept
+	done(false)
+	doneC, err := l.CheckBatch("agent", []string{"c"})
+	require.NoError(t, err)
+	_, err = l.CheckBatch("agent", []string{"b"})
+	require.ErrorIs(t, err, ErrTooManyNames)
+	doneOther(true)
+	doneC(true)
+	done, err = l.CheckBatch("agent", []string{"a", "c"})
+	require.NoError(t, err)
+	done(false)
+	_, err = l.CheckBatch("agent", []string{"b"})
+	require.ErrorIs(t, err, ErrTooManyNames)
+}
+
+func TestMiddleware(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := newLimiter(clock, OptionWithRate(0.5, 1), OptionWithMaxBody(10))
+	var source string
+	h := Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source = FromContext(r.Context())
+	}))
+	do := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	//address set by client is not trusted
+	r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("[]"))
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Real-IP", "10.0.0.2")
+	w := do(r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "10.0.0.1", source)
+
+	r = httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("[]"))
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Real-IP", "10.0.0.3")
+	w = do(r)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	//tenant is source of request
+	r = httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("[]"))
+	r.Header.Set("X-Real-IP", "10.0.0.1")
+	r = r.WithContext(tenant.NewContext(r.Context(), "team-a"))
+	w = do(r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "tenant:team-a", source)
+
+	//address of agent is set by proxy from trusted subnet
+	OptionWithTrustedSubnet("192.168.0.0/24")(l)
+	r = httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("[]"))
+	r.RemoteAddr = "192.168.0.1:1234"
+	r.Header.Set("X-Real-IP", "10.0.0.1")
+	w = do(r)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	r = httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("[]"))
+	r.RemoteAddr = "192.168.0.1:1234"
+	r.Header.Set("X-Real-IP", "10.0.0.4")
+	w = do(r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "10.0.0.4", source)
+
+	//body size is checked when content length is unknown
+	r = httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(strings.Repeat(" ", 11)))
+	r.ContentLength = -1
+	w = do(r)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := newLimiter(clock, OptionWithRate(1, 1), OptionWithMaxBatch(1))
+	interceptor := UnaryServerInterceptor(l)
+	handler := func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	}
+	metric := func(id string) *pb.Metric {
+		return &pb.Metric{Id: &pb.MetricId{Id: id}, Type: pb.MetricType_COUNTER, Delta: &pb.MetricDelta{Delta: 1}}
+	}
+
+	_, err := interceptor(context.Background(), &pb.BatchUpdateRequest{Metric: []*pb.Metric{metric("a"), metric("b")}}, &grpc.UnaryServerInfo{}, handler)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	clock.t = clock.t.Add(time.Second)
+	_, err = interceptor(context.Background(), &pb.UpdateRequest{Metric: metric("a")}, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+
+	_, err = interceptor(context.Background(), &pb.UpdateRequest{Metric: metric("a")}, &grpc.UnaryServerInfo{}, handler)
+	st := status.Convert(err)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1)
+	info, ok := st.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	require.Equal(t, time.Second, info.GetRetryDelay().AsDuration())
+
+	//reads are not limited
+	_, err = interceptor(context.Background(), &pb.GetRequest{Id: &pb.MetricId{Id: "a"}}, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+}
@@ -0,0 +1,146 @@
+package limits
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/access"
+	pb "github.com/esafronov/yp-metrics/internal/grpc/proto"
+	"github.com/esafronov/yp-metrics/internal/tenant"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Middleware server middleware limiting request rate and body size of source,
+// source is put into request context for checking batches by handlers
+func Middleware(l *Limiter) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			source := l.httpSource(r)
+			if err := l.Allow(source); err != nil {
+				WriteError(w, err)
+				return
+			}
+			if l.maxBody > 0 {
+				if r.ContentLength > l.maxBody {
+					WriteError(w, ErrBodyTooLarge)
+					return
+				}
+				//body is read here so limit is applied regardless of who reads it further
+				body, err := io.ReadAll(io.LimitReader(r.Body, l.maxBody+1))
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+				if int64(len(body)) > l.maxBody {
+					WriteError(w, ErrBodyTooLarge)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			h.ServeHTTP(w, r.WithContext(NewContext(r.Context(), source)))
+		})
+	}
+}
+
+// WriteError responds with status matching limit error
+func WriteError(w http.ResponseWriter, err error) {
+	var rateErr *RateError
+	switch {
+	case errors.As(err, &rateErr):
+		seconds := int(rateErr.RetryAfter.Round(time.Second) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, ErrBatchTooLarge), errors.Is(err, ErrBodyTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	}
+}
+
+// UnaryServerInterceptor is the interceptor for gRPC server limiting request rate and batches of source.
+// Message size is limited by grpc.MaxRecvMsgSize server option
+func UnaryServerInterceptor(l *Limiter) func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var names []string
+		switch r := req.(type) {
+		case *pb.UpdateRequest:
+			names = []string{r.GetMetric().GetId().GetId()}
+		case *pb.BatchUpdateRequest:
+			for _, m := range r.GetMetric() {
+				names = append(names, m.GetId().GetId())
+			}
+		default:
+			//only updates are limited
+			return handler(ctx, req)
+		}
+		source := grpcSource(ctx)
+		if err := l.Allow(source); err != nil {
+			return nil, Status(err)
+		}
+		done, err := l.CheckBatch(source, names)
+		if err != nil {
+			return nil, Status(err)
+		}
+		resp, err := handler(NewContext(ctx, source), req)
+		done(err == nil)
+		return resp, err
+	}
+}
+
+// Status converts limit error to gRPC status with retry hint
+func Status(err error) error {
+	st := status.New(codes.ResourceExhausted, err.Error())
+	var rateErr *RateError
+	if errors.As(err, &rateErr) {
+		if withDetails, derr := st.WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(rateErr.RetryAfter),
+		}); derr == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+// httpSource returns tenant of request or address of agent,
+// address set in X-Real-IP header is used only if request comes from trusted subnet
+func (l *Limiter) httpSource(r *http.Request) string {
+	if id := tenant.FromContext(r.Context()); id != tenant.Default {
+		return "tenant:" + string(id)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(r.Header.Get(access.HeaderIp)); ip != nil && l.trusted != nil {
+		if remote := net.ParseIP(host); remote != nil && l.trusted.Contains(remote) {
+			return ip.String()
+		}
+	}
+	return host
+}
+
+// grpcSource returns tenant of request or address of agent
+func grpcSource(ctx context.Context) string {
+	if id := tenant.FromContext(ctx); id != tenant.Default {
+		return "tenant:" + string(id)
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
@@ -1,35 +1,45 @@
-// Package pg singleton includes Connect and Close functions for pgx driver of postgress
+// Package pg includes constructor of pgx connection pool for postgres
 package pg
 
 import (
-	"database/sql"
+	"context"
 	"errors"
+	"time"
 
-	"github.com/esafronov/yp-metrics/internal/logger"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-var DB *sql.DB
+// Config pool settings, zero values keep pgx defaults
+type Config struct {
+	Dsn                    string        //db connection dsn
+	MaxConns               int32         //max number of connections in pool
+	MaxConnLifetime        time.Duration //connection is closed after this duration
+	StatementCacheCapacity int           //prepared statements cached per connection, negative disables cache
+}
 
-// Connect open connection and sets DB variable for singleton
-func Connect(databaseDsn *string) error {
-	if databaseDsn == nil {
-		return errors.New("databaseDsn is nil")
+// NewPool creates pgx pool configured with cfg
+func NewPool(ctx context.Context, cfg Config) (*pgxpool.Pool, error) {
+	if cfg.Dsn == "" {
+		return nil, errors.New("databaseDsn is empty")
 	}
-	db, err := sql.Open("pgx", *databaseDsn)
+	poolConfig, err := pgxpool.ParseConfig(cfg.Dsn)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	DB = db
-	return nil
-}
-
-// Close connection DB
-func Close() {
-	if DB != nil {
-		err := DB.Close()
-		if err != nil {
-			logger.Log.Info(err.Error())
-		}
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	switch {
+	case cfg.StatementCacheCapacity > 0:
+		poolConfig.ConnConfig.StatementCacheCapacity = cfg.StatementCacheCapacity
+		poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	case cfg.StatementCacheCapacity < 0:
+		//statements are prepared and described on each call
+		poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
 	}
+	return pgxpool.NewWithConfig(ctx, poolConfig)
 }
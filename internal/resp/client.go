@@ -0,0 +1,248 @@
+// Package resp implements minimal client of Redis serialization protocol (RESP2) with connection pool and pipelining
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error is error reply of server
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// ErrClosed is returned by Client after Close
+var ErrClosed = errors.New("resp client is closed")
+
+// defaultPoolSize max idle connections kept by client
+const defaultPoolSize int = 10
+
+// defaultDialTimeout timeout for establishing connection
+const defaultDialTimeout = 5 * time.Second
+
+// defaultCommandTimeout timeout of command or pipeline round trip if context deadline is later or not set
+const defaultCommandTimeout = 5 * time.Second
+
+// Client is safe for concurrent use, every call takes connection from pool
+type Client struct {
+	idle     chan *conn
+	addr     string
+	password string
+	mu       sync.Mutex
+	db       int
+	timeout  time.Duration //max duration of round trip
+	closed   bool
+}
+
+// OptionWithPassword option function to configure Client to authenticate with password
+func OptionWithPassword(password string) func(c *Client) {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+// OptionWithDB option function to configure Client to select database number
+func OptionWithDB(db int) func(c *Client) {
+	return func(c *Client) {
+		c.db = db
+	}
+}
+
+// OptionWithPoolSize option function to configure Client max idle connections
+func OptionWithPoolSize(size int) func(c *Client) {
+	return func(c *Client) {
+		if size > 0 {
+			c.idle = make(chan *conn, size)
+		}
+	}
+}
+
+// OptionWithTimeout option function to configure Client max duration of command round trip
+func OptionWithTimeout(timeout time.Duration) func(c *Client) {
+	return func(c *Client) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// NewClient is factory method, connections are established on demand
+func NewClient(addr string, opts ...func(c *Client)) *Client {
+	c := &Client{
+		addr:    addr,
+		idle:    make(chan *conn, defaultPoolSize),
+		timeout: defaultCommandTimeout,
+	}
+	for _, f := range opts {
+		f(c)
+	}
+	return c
+}
+
+// Do sends one command and returns its reply: string, int64, []any, nil for null reply or Error
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.Pipeline(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := replies[0].(Error); ok {
+		return nil, e
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends all commands at once and reads their replies in the same order,
+// error replies of separate commands are returned as Error values in replies
+func (c *Client) Pipeline(ctx context.Context, cmds [][]string) ([]any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := cn.roundTrip(ctx, cmds)
+	if err != nil {
+		//connection state is unknown after io error
+		cn.close()
+		return nil, err
+	}
+	c.put(cn)
+	return replies, nil
+}
+
+// Close closes idle connections, connections in use are closed when returned
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.idle)
+	for cn := range c.idle {
+		cn.close()
+	}
+	return nil
+}
+
+// get takes idle connection or dials new one
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	select {
+	case cn := <-c.idle:
+		c.mu.Unlock()
+		return cn, nil
+	default:
+	}
+	c.mu.Unlock()
+	return c.dial(ctx)
+}
+
+// put returns connection to pool or closes it if pool is full
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cn.close()
+		return
+	}
+	select {
+	case c.idle <- cn:
+	default:
+		cn.close()
+	}
+}
+
+// dial establishes connection, authenticates and selects database
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	d := net.Dialer{Timeout: defaultDialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{nc: nc, rd: bufio.NewReader(nc), wr: bufio.NewWriter(nc), timeout: c.timeout}
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if len(setup) == 0 {
+		return cn, nil
+	}
+	replies, err := cn.roundTrip(ctx, setup)
+	if err != nil {
+		cn.close()
+		return nil, err
+	}
+	for _, r := range replies {
+		if e, ok := r.(Error); ok {
+			cn.close()
+			return nil, fmt.Errorf("connection setup: %w", e)
+		}
+	}
+	return cn, nil
+}
+
+// conn single server connection with buffered reader and writer
+type conn struct {
+	nc      net.Conn
+	rd      *bufio.Reader
+	wr      *bufio.Writer
+	timeout time.Duration
+}
+
+// roundTrip writes commands and reads one reply per command. Io deadline is conn timeout or context deadline
+// if it is earlier, canceled context interrupts io by closing connection
+func (cn *conn) roundTrip(ctx context.Context, cmds [][]string) ([]any, error) {
+	deadline := time.Now().Add(cn.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := cn.nc.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, cn.close)
+	replies, err := cn.exchange(cmds)
+	if !stop() {
+		//connection is closed by canceled context
+		return nil, ctx.Err()
+	}
+	return replies, err
+}
+
+// exchange writes commands and reads their replies
+func (cn *conn) exchange(cmds [][]string) ([]any, error) {
+	for _, args := range cmds {
+		if err := WriteCommand(cn.wr, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := cn.wr.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]any, 0, len(cmds))
+	for range cmds {
+		r, err := ReadReply(cn.rd)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, r)
+	}
+	return replies, nil
+}
+
+func (cn *conn) close() {
+	_ = cn.nc.Close()
+}
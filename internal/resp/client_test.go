@@ -0,0 +1,138 @@
+package resp_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/resp"
+	"github.com/esafronov/yp-metrics/internal/resp/resptest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplyRoundTrip(t *testing.T) {
+	tests := []struct {
+		v    any
+		name string
+	}{
+		{name: "bulk string", v: "hello"},
+		{name: "empty string", v: ""},
+		{name: "string with CRLF", v: "a\r\nb"},
+		{name: "integer", v: int64(-42)},
+		{name: "null", v: nil},
+		{name: "error", v: resp.Error("ERR boom")},
+		{name: "nested array", v: []any{"a", int64(1), []any{nil, "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := bufio.NewWriter(&buf)
+			require.NoError(t, resp.WriteReply(w, tt.v))
+			require.NoError(t, w.Flush())
+			got, err := resp.ReadReply(bufio.NewReader(&buf))
+			require.NoError(t, err)
+			require.Equal(t, tt.v, got)
+		})
+	}
+}
+
+func TestReadReply_Malformed(t *testing.T) {
+	for _, in := range []string{"?x\r\n", ":abc\r\n", "+no crlf\n", "$5\r\nab"} {
+		_, err := resp.ReadReply(bufio.NewReader(bytes.NewBufferString(in)))
+		require.Error(t, err, in)
+	}
+}
+
+func TestClient_Pipeline(t *testing.T) {
+	srv := resptest.NewServer()
+	defer srv.Close()
+	c := resp.NewClient(srv.Addr(), resp.OptionWithPoolSize(1))
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	replies, err := c.Pipeline(ctx, [][]string{
+		{"HINCRBY", "h", "f", "2"},
+		{"HINCRBY", "h", "f", "3"},
+		{"HGET", "h", "f"},
+		{"HGET", "h", "missing"},
+		{"NOSUCHCOMMAND"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), replies[0])
+	require.Equal(t, int64(5), replies[1])
+	require.Equal(t, "5", replies[2])
+	require.Nil(t, replies[3])
+	require.IsType(t, resp.Error(""), replies[4])
+
+	//error reply of single command is returned as error, connection stays usable
+	_, err = c.Do(ctx, "NOSUCHCOMMAND")
+	require.Error(t, err)
+	v, err := c.Do(ctx, "PING")
+	require.NoError(t, err)
+	require.Equal(t, "PONG", v)
+}
+
+func TestClient_Closed(t *testing.T) {
+	srv := resptest.NewServer()
+	defer srv.Close()
+	c := resp.NewClient(srv.Addr())
+	require.NoError(t, c.Close())
+	_, err := c.Do(context.Background(), "PING")
+	require.ErrorIs(t, err, resp.ErrClosed)
+}
+
+// stalled server accepts connections and never replies
+func newStalledServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() {
+				_ = c.Close()
+			})
+		}
+	}()
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	return l
+}
+
+func TestClient_Timeout(t *testing.T) {
+	l := newStalledServer(t)
+	c := resp.NewClient(l.Addr().String(), resp.OptionWithTimeout(100*time.Millisecond))
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+	//context without deadline doesn't block command forever
+	start := time.Now()
+	_, err := c.Do(context.Background(), "PING")
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	require.True(t, netErr.Timeout())
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestClient_Canceled(t *testing.T) {
+	l := newStalledServer(t)
+	c := resp.NewClient(l.Addr().String())
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := c.Do(ctx, "PING")
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,147 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ErrProtocol is returned for malformed replies
+var ErrProtocol = errors.New("resp protocol error")
+
+// WriteCommand writes command as array of bulk strings
+func WriteCommand(w *bufio.Writer, args ...string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, a := range args {
+		if err := WriteBulk(w, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteBulk writes bulk string
+func WriteBulk(w *bufio.Writer, s string) error {
+	_, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+	return err
+}
+
+// WriteReply writes reply value: string as bulk string, int64, []any as array, nil as null bulk string, Error
+func WriteReply(w *bufio.Writer, v any) error {
+	var err error
+	switch val := v.(type) {
+	case nil:
+		_, err = w.WriteString("$-1\r\n")
+	case string:
+		err = WriteBulk(w, val)
+	case int64:
+		_, err = fmt.Fprintf(w, ":%d\r\n", val)
+	case Error:
+		_, err = fmt.Fprintf(w, "-%s\r\n", string(val))
+	case []any:
+		if _, err = fmt.Fprintf(w, "*%d\r\n", len(val)); err != nil {
+			return err
+		}
+		for _, item := range val {
+			if err = WriteReply(w, item); err != nil {
+				return err
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported reply type %T", v)
+	}
+	return err
+}
+
+// ReadReply reads one reply: simple and bulk strings as string, integers as int64,
+// arrays as []any, null bulk string or array as nil, error reply as Error
+func ReadReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, ErrProtocol
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := ReadReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, ErrProtocol
+	}
+}
+
+// ReadCommand reads command sent by client as array of bulk strings
+func ReadCommand(r *bufio.Reader) ([]string, error) {
+	v, err := ReadReply(r)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := v.([]any)
+	if !ok {
+		return nil, ErrProtocol
+	}
+	args := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, ErrProtocol
+		}
+		args = append(args, s)
+	}
+	return args, nil
+}
+
+// readLine reads line without trailing CRLF
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", ErrProtocol
+	}
+	return line[:len(line)-2], nil
+}
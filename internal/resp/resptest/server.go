@@ -0,0 +1,287 @@
+// Package resptest provides in-process RESP server for tests, it supports subset of Redis commands used by storage
+package resptest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/esafronov/yp-metrics/internal/resp"
+)
+
+// Server keeps hashes in memory and serves them over RESP on loopback interface
+type Server struct {
+	listener net.Listener
+	hashes   map[string]map[string]string
+	conns    map[net.Conn]struct{}
+	password string
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	commands int
+}
+
+// NewServer starts server on random local port, caller should call Close when finished
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("resptest: failed to listen: " + err.Error())
+	}
+	s := &Server{
+		listener: l,
+		hashes:   map[string]map[string]string{},
+		conns:    map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Addr returns host:port server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// RequirePass makes server reject commands until client is authenticated with password
+func (s *Server) RequirePass(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// Commands returns number of commands processed, MULTI and EXEC included
+func (s *Server) Commands() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands
+}
+
+// HGet returns hash field directly from server memory
+func (s *Server) HGet(key, field string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.hashes[key][field]
+	return v, ok
+}
+
+// Close stops listening and drops client connections
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+// session state of one client connection
+type session struct {
+	queue  [][]string
+	authed bool
+	multi  bool
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		_ = c.Close()
+	}()
+	rd := bufio.NewReader(c)
+	wr := bufio.NewWriter(c)
+	ss := &session{}
+	for {
+		args, err := resp.ReadCommand(rd)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				_ = resp.WriteReply(wr, resp.Error("ERR "+err.Error()))
+				_ = wr.Flush()
+			}
+			return
+		}
+		if err := resp.WriteReply(wr, s.dispatch(ss, args)); err != nil {
+			return
+		}
+		//flush when client pipeline is fully read
+		if rd.Buffered() == 0 {
+			if err := wr.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// dispatch handles transaction and authentication commands, others are executed by exec
+func (s *Server) dispatch(ss *session, args []string) any {
+	if len(args) == 0 {
+		return resp.Error("ERR empty command")
+	}
+	name := strings.ToUpper(args[0])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands++
+	if name == "AUTH" {
+		if len(args) != 2 || args[1] != s.password {
+			return resp.Error("WRONGPASS invalid password")
+		}
+		ss.authed = true
+		return "OK"
+	}
+	if s.password != "" && !ss.authed {
+		return resp.Error("NOAUTH Authentication required.")
+	}
+	switch name {
+	case "MULTI":
+		if ss.multi {
+			return resp.Error("ERR MULTI calls can not be nested")
+		}
+		ss.multi = true
+		ss.queue = nil
+		return "OK"
+	case "DISCARD":
+		if !ss.multi {
+			return resp.Error("ERR DISCARD without MULTI")
+		}
+		ss.multi = false
+		ss.queue = nil
+		return "OK"
+	case "EXEC":
+		if !ss.multi {
+			return resp.Error("ERR EXEC without MULTI")
+		}
+		//queued commands are executed under one lock, so transaction is atomic
+		replies := make([]any, 0, len(ss.queue))
+		for _, cmd := range ss.queue {
+			replies = append(replies, s.exec(strings.ToUpper(cmd[0]), cmd[1:]))
+		}
+		ss.multi = false
+		ss.queue = nil
+		return replies
+	}
+	if ss.multi {
+		ss.queue = append(ss.queue, args)
+		return "QUEUED"
+	}
+	return s.exec(name, args[1:])
+}
+
+// exec runs one command, caller holds lock
+func (s *Server) exec(name string, args []string) any {
+	switch name {
+	case "PING":
+		return "PONG"
+	case "SELECT":
+		return "OK"
+	case "FLUSHALL", "FLUSHDB":
+		s.hashes = map[string]map[string]string{}
+		return "OK"
+	case "DEL":
+		var n int64
+		for _, key := range args {
+			if _, ok := s.hashes[key]; ok {
+				delete(s.hashes, key)
+				n++
+			}
+		}
+		return n
+	case "HSET":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return wrongArgs(name)
+		}
+		h := s.hash(args[0])
+		var n int64
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return n
+	case "HGET":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		if v, ok := s.hashes[args[0]][args[1]]; ok {
+			return v
+		}
+		return nil
+	case "HDEL":
+		if len(args) < 2 {
+			return wrongArgs(name)
+		}
+		var n int64
+		h := s.hashes[args[0]]
+		for _, field := range args[1:] {
+			if _, ok := h[field]; ok {
+				delete(h, field)
+				n++
+			}
+		}
+		return n
+	case "HGETALL":
+		if len(args) != 1 {
+			return wrongArgs(name)
+		}
+		h := s.hashes[args[0]]
+		items := make([]any, 0, len(h)*2)
+		for field, v := range h {
+			items = append(items, field, v)
+		}
+		return items
+	case "HINCRBY":
+		if len(args) != 3 {
+			return wrongArgs(name)
+		}
+		delta, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return resp.Error("ERR value is not an integer or out of range")
+		}
+		h := s.hash(args[0])
+		var cur int64
+		if v, ok := h[args[1]]; ok {
+			if cur, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return resp.Error("ERR hash value is not an integer")
+			}
+		}
+		cur += delta
+		h[args[1]] = strconv.FormatInt(cur, 10)
+		return cur
+	default:
+		return resp.Error("ERR unknown command '" + name + "'")
+	}
+}
+
+// hash returns hash by key, creates it if absent
+func (s *Server) hash(key string) map[string]string {
+	h, ok := s.hashes[key]
+	if !ok {
+		h = map[string]string{}
+		s.hashes[key] = h
+	}
+	return h
+}
+
+func wrongArgs(name string) resp.Error {
+	return resp.Error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+}
@@ -0,0 +1,38 @@
+package retry
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultSchedule returns copy of default retries schedule
+func DefaultSchedule() []time.Duration {
+	schedule := make([]time.Duration, len(retriesSchedule))
+	copy(schedule, retriesSchedule)
+	return schedule
+}
+
+// Do calls f until it succeeds, returns not retriable error or tries by schedule are over.
+// Every schedule entry is one try, its duration is pause before next try, empty schedule means single try.
+// Pause is not started if context deadline comes earlier, last error is returned then.
+// Returns number of made retries and last error
+func Do(ctx context.Context, schedule []time.Duration, retriable func(error) bool, f func() error) (retries int, err error) {
+	for n := 0; ; n++ {
+		err = f()
+		if err == nil || n >= len(schedule)-1 || !retriable(err) {
+			return
+		}
+		pause := schedule[n]
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < pause {
+			return
+		}
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		retries++
+	}
+}
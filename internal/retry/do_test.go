@@ -0,0 +1,79 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errTransient = errors.New("transient")
+
+func isTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestDo(t *testing.T) {
+	schedule := []time.Duration{time.Millisecond, time.Millisecond, 0}
+	tests := []struct {
+		errs        []error
+		wantErr     error
+		name        string
+		wantCalls   int
+		wantRetries int
+	}{
+		{
+			name:      "success on first try",
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name:        "success after retries",
+			errs:        []error{errTransient, errTransient, nil},
+			wantCalls:   3,
+			wantRetries: 2,
+		},
+		{
+			name:        "tries are over",
+			errs:        []error{errTransient, errTransient, errTransient, nil},
+			wantErr:     errTransient,
+			wantCalls:   3,
+			wantRetries: 2,
+		},
+		{
+			name:      "not retriable error",
+			errs:      []error{errors.New("fatal"), nil},
+			wantErr:   errors.New("fatal"),
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			retries, err := Do(context.Background(), schedule, isTransient, func() error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+			require.Equal(t, tt.wantErr, err)
+			require.Equal(t, tt.wantCalls, calls)
+			require.Equal(t, tt.wantRetries, retries)
+		})
+	}
+}
+
+func TestDo_Deadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls := 0
+	start := time.Now()
+	_, err := Do(ctx, DefaultSchedule(), isTransient, func() error {
+		calls++
+		return errTransient
+	})
+	require.ErrorIs(t, err, errTransient)
+	require.Equal(t, 1, calls, "пауза длиннее дедлайна не должна начинаться")
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -11,6 +11,12 @@ import (
 // DoRequest http request with retries
 func DoRequest(req *http.Request) (res *http.Response, err error) {
 	for n, t := range retriesSchedule {
+		if n > 0 && req.GetBody != nil {
+			//body was consumed by previous try
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
 		res, err = http.DefaultClient.Do(req)
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
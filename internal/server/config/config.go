@@ -10,18 +10,48 @@ import (
 )
 
 type AppParams struct {
-	Address              *string `env:"ADDRESS" json:"address"`               //server address to listen
-	StoreInterval        *int    `env:"STORE_INTERVAL" json:"restore"`        //store interval
-	FileStoragePath      *string `env:"FILE_STORAGE_PATH" json:"store_file"`  //file storage path
-	Restore              *bool   `env:"RESTORE"`                              //restore or not data on start
-	DatabaseDsn          *string `env:"DATABASE_DSN" json:"database_dsn"`     //db connection dsn
-	SecretKey            *string `env:"KEY"`                                  //secret key for signature check
-	ProfileServerAddress *string `env:"PROFILE_SERVER_ADDRESS"`               //profile serveraddress to listen
-	CryptoKey            *string `env:"CRYPTO_KEY" json:"crypto_key"`         //Full filepath to RSA private key
-	Config               *string `env:"CONFIG" json:"-"`                      //filepath to config file
-	TrustedSubnet        *string `env:"TRUSTED_SUBNET" json:"trusted_subnet"` //trusted subnet
-	UseGRPC              *bool   `env:"USE_GRPC"`                             //run gRPC server instead of http server if true, run http server by default
-	CryptoCert           *string `env:"CRYPTO_CERT"`                          //server sertificate
+	Address              *string  `env:"ADDRESS" json:"address"`                                   //server address to listen
+	StoreInterval        *int     `env:"STORE_INTERVAL" json:"restore"`                            //store interval
+	FileStoragePath      *string  `env:"FILE_STORAGE_PATH" json:"store_file"`                      //file storage path
+	Restore              *bool    `env:"RESTORE"`                                                  //restore or not data on start
+	DatabaseDsn          *string  `env:"DATABASE_DSN" json:"database_dsn"`                         //db connection dsn
+	SecretKey            *string  `env:"KEY"`                                                      //secret key for signature check
+	ProfileServerAddress *string  `env:"PROFILE_SERVER_ADDRESS"`                                   //profile serveraddress to listen
+	CryptoKey            *string  `env:"CRYPTO_KEY" json:"crypto_key"`                             //Full filepath to RSA private key
+	Config               *string  `env:"CONFIG" json:"-"`                                          //filepath to config file
+	TrustedSubnet        *string  `env:"TRUSTED_SUBNET" json:"trusted_subnet"`                     //trusted subnet
+	UseGRPC              *bool    `env:"USE_GRPC"`                                                 //run gRPC server instead of http server if true, run http server by default
+	CryptoCert           *string  `env:"CRYPTO_CERT"`                                              //server sertificate
+	DatabaseMaxConns     *int     `env:"DATABASE_MAX_CONNS" json:"database_max_conns"`             //max connections in db pool, 0 = pgx default
+	DatabaseConnLifetime *int     `env:"DATABASE_CONN_LIFETIME" json:"database_conn_lifetime"`     //db connection lifetime in seconds, 0 = pgx default
+	DatabaseStmtCache    *int     `env:"DATABASE_STATEMENT_CACHE" json:"database_statement_cache"` //prepared statements cache size per connection, -1 disables cache
+	DatabaseReplicaDsn   *string  `env:"DATABASE_REPLICA_DSN" json:"database_replica_dsn"`         //comma separated dsn list of read replicas
+	DatabaseReplicaLag   *int     `env:"DATABASE_REPLICA_MAX_LAG" json:"database_replica_max_lag"` //max replica lag in seconds for reading from it, 0 = not limited
+	RedisAddress         *string  `env:"REDIS_ADDRESS" json:"redis_address"`                       //redis host:port, redis storage is used if set
+	RedisPassword        *string  `env:"REDIS_PASSWORD" json:"redis_password"`                     //redis password
+	RedisDB              *int     `env:"REDIS_DB" json:"redis_db"`                                 //redis database number
+	CacheTTL             *int     `env:"CACHE_TTL" json:"cache_ttl"`                               //ttl in milliseconds of metrics cached in front of db or redis, 0 disables cache
+	CacheSize            *int     `env:"CACHE_SIZE" json:"cache_size"`                             //max number of cached metrics
+	BufferInterval       *int     `env:"BUFFER_FLUSH_INTERVAL" json:"buffer_flush_interval"`       //flush interval in milliseconds of write-behind buffer in front of db or redis, 0 disables buffer
+	BufferSize           *int     `env:"BUFFER_FLUSH_SIZE" json:"buffer_flush_size"`               //number of buffered metrics which triggers flush
+	BufferLimit          *int     `env:"BUFFER_LIMIT" json:"buffer_limit"`                         //max number of buffered metrics, writes flush synchronously when it is reached
+	BufferMaxFailures    *int     `env:"BUFFER_MAX_FAILURES" json:"buffer_max_failures"`           //consecutive failed flushes after which buffered metrics are dropped
+	Tenants              *bool    `env:"TENANTS" json:"tenants"`                                   //isolate metrics of tenants given by X-Tenant-ID header, JWT claim or gRPC metadata
+	TenantJWTKey         *string  `env:"TENANT_JWT_KEY" json:"-"`                                  //HMAC key of tenant JWT, tenant header is not trusted if set
+	TenantAnonymous      *bool    `env:"TENANT_ANONYMOUS" json:"tenant_anonymous"`                 //requests without JWT belong to default tenant instead of being rejected
+	TenantQuota          *int     `env:"TENANT_QUOTA" json:"tenant_quota"`                         //max metrics per tenant, 0 = not limited
+	RateLimit            *float64 `env:"RATE_LIMIT" json:"rate_limit"`                             //update requests per second of tenant or agent, 0 = not limited
+	RateBurst            *int     `env:"RATE_BURST" json:"rate_burst"`                             //max update requests of tenant or agent in burst
+	MaxBatchSize         *int     `env:"MAX_BATCH_SIZE" json:"max_batch_size"`                     //max metrics per update request, 0 = not limited
+	MaxSourceMetrics     *int     `env:"MAX_SOURCE_METRICS" json:"max_source_metrics"`             //max distinct metrics of tenant or agent, 0 = not limited
+	MaxBodySize          *int     `env:"MAX_BODY_SIZE" json:"max_body_size"`                       //max update request body in bytes, 0 = not limited
+	MetricNameMaxLength  *int     `env:"METRIC_NAME_MAX_LENGTH" json:"metric_name_max_length"`     //max length of metric name
+	MetricNameReserved   *string  `env:"METRIC_NAME_RESERVED" json:"metric_name_reserved"`         //comma separated prefixes which metric names can't start with
+	MaxMetrics           *int     `env:"MAX_METRICS" json:"max_metrics"`                           //max number of stored metrics, 0 = not limited
+	BatchDedupWindow     *int     `env:"BATCH_DEDUP_WINDOW" json:"batch_dedup_window"`             //window in seconds of remembered batch ids, retried batch isn't applied twice, 0 disables deduplication
+	BatchDedupSize       *int     `env:"BATCH_DEDUP_SIZE" json:"batch_dedup_size"`                 //max number of remembered batch ids
+	StatsInterval        *int     `env:"STATS_INTERVAL" json:"stats_interval"`                     //interval in seconds of logging storage retry and cache counters, 0 disables logging
+	DumpAPI              *bool    `env:"DUMP_API" json:"dump_api"`                                 //serve /export and /import of all metrics
 }
 
 var Params *AppParams = &AppParams{}
@@ -45,6 +75,36 @@ var trustedSubnetFlag *string
 var useGRPCFlag *bool
 var configFlag *string
 var cryptoCertFlag *string
+var databaseMaxConnsFlag *int
+var databaseConnLifetimeFlag *int
+var databaseStmtCacheFlag *int
+var databaseReplicaDsnFlag *string
+var databaseReplicaLagFlag *int
+var redisAddressFlag *string
+var redisPasswordFlag *string
+var redisDBFlag *int
+var cacheTTLFlag *int
+var cacheSizeFlag *int
+var bufferIntervalFlag *int
+var bufferSizeFlag *int
+var bufferLimitFlag *int
+var bufferMaxFailuresFlag *int
+var tenantsFlag *bool
+var tenantJWTKeyFlag *string
+var tenantAnonymousFlag *bool
+var tenantQuotaFlag *int
+var rateLimitFlag *float64
+var rateBurstFlag *int
+var maxBatchSizeFlag *int
+var maxSourceMetricsFlag *int
+var maxBodySizeFlag *int
+var metricNameMaxLengthFlag *int
+var metricNameReservedFlag *string
+var maxMetricsFlag *int
+var batchDedupWindowFlag *int
+var batchDedupSizeFlag *int
+var statsIntervalFlag *int
+var dumpAPIFlag *bool
 
 func parseFlags() {
 	serverAddressFlag = flag.String("a", "localhost:8080", "address and port to run server")
@@ -58,6 +118,36 @@ func parseFlags() {
 	trustedSubnetFlag = flag.String("t", "", "Trusted subnet")
 	useGRPCFlag = flag.Bool("g", false, "Run gRPC server instead of http server")
 	cryptoCertFlag = flag.String("s", "", "Full filepath to RSA certificate (using it for )")
+	databaseMaxConnsFlag = flag.Int("dmc", 0, "max connections in db pool, 0 = pgx default")
+	databaseConnLifetimeFlag = flag.Int("dcl", 0, "db connection lifetime in seconds, 0 = pgx default")
+	databaseStmtCacheFlag = flag.Int("dsc", 512, "prepared statements cache size per db connection, -1 disables cache")
+	databaseReplicaDsnFlag = flag.String("dr", "", "comma separated dsn list of read replicas")
+	databaseReplicaLagFlag = flag.Int("drl", 5, "max replica lag in seconds for reading from it, 0 = not limited")
+	redisAddressFlag = flag.String("redis", "", "redis host:port, redis storage is used if set")
+	redisPasswordFlag = flag.String("redis-password", "", "redis password")
+	redisDBFlag = flag.Int("redis-db", 0, "redis database number")
+	cacheTTLFlag = flag.Int("cache-ttl", 0, "ttl in milliseconds of metrics cached in front of db or redis, 0 disables cache")
+	cacheSizeFlag = flag.Int("cache-size", 10000, "max number of cached metrics")
+	bufferIntervalFlag = flag.Int("buffer-interval", 0, "flush interval in milliseconds of write-behind buffer in front of db or redis, 0 disables buffer")
+	bufferSizeFlag = flag.Int("buffer-size", 1000, "number of buffered metrics which triggers flush")
+	bufferLimitFlag = flag.Int("buffer-limit", 10000, "max number of buffered metrics, writes flush synchronously when it is reached")
+	bufferMaxFailuresFlag = flag.Int("buffer-max-failures", 30, "consecutive failed flushes after which buffered metrics are dropped")
+	tenantsFlag = flag.Bool("tenants", false, "isolate metrics of tenants given by X-Tenant-ID header, JWT claim or gRPC metadata")
+	tenantJWTKeyFlag = flag.String("tenant-jwt-key", "", "HMAC key of tenant JWT, tenant header is not trusted if set")
+	tenantAnonymousFlag = flag.Bool("tenant-anonymous", false, "requests without JWT belong to default tenant instead of being rejected")
+	tenantQuotaFlag = flag.Int("tenant-quota", 0, "max metrics per tenant, 0 = not limited")
+	rateLimitFlag = flag.Float64("rate-limit", 0, "update requests per second of tenant or agent, 0 = not limited")
+	rateBurstFlag = flag.Int("rate-burst", 10, "max update requests of tenant or agent in burst")
+	maxBatchSizeFlag = flag.Int("max-batch", 0, "max metrics per update request, 0 = not limited")
+	maxSourceMetricsFlag = flag.Int("max-source-metrics", 0, "max distinct metrics of tenant or agent, 0 = not limited")
+	maxBodySizeFlag = flag.Int("max-body", 0, "max update request body in bytes, 0 = not limited")
+	metricNameMaxLengthFlag = flag.Int("name-max-length", 255, "max length of metric name")
+	metricNameReservedFlag = flag.String("name-reserved", "", "comma separated prefixes which metric names can't start with")
+	maxMetricsFlag = flag.Int("max-metrics", 0, "max number of stored metrics, 0 = not limited")
+	batchDedupWindowFlag = flag.Int("batch-dedup-window", 300, "window in seconds of remembered batch ids, retried batch isn't applied twice, 0 disables deduplication")
+	batchDedupSizeFlag = flag.Int("batch-dedup-size", 100000, "max number of remembered batch ids")
+	statsIntervalFlag = flag.Int("stats-interval", 60, "interval in seconds of logging storage retry and cache counters, 0 disables logging")
+	dumpAPIFlag = flag.Bool("dump-api", false, "serve /export and /import of all metrics")
 	configFlag = flag.String("config", "", "filepath to config file")
 	flag.StringVar(configFlag, "c", *configFlag, "alias for -config")
 	flag.Parse()
@@ -97,6 +187,96 @@ func SetFlags() {
 	if Params.CryptoCert == nil {
 		Params.CryptoCert = cryptoCertFlag
 	}
+	if Params.DatabaseMaxConns == nil {
+		Params.DatabaseMaxConns = databaseMaxConnsFlag
+	}
+	if Params.DatabaseConnLifetime == nil {
+		Params.DatabaseConnLifetime = databaseConnLifetimeFlag
+	}
+	if Params.DatabaseStmtCache == nil {
+		Params.DatabaseStmtCache = databaseStmtCacheFlag
+	}
+	if Params.DatabaseReplicaDsn == nil {
+		Params.DatabaseReplicaDsn = databaseReplicaDsnFlag
+	}
+	if Params.DatabaseReplicaLag == nil {
+		Params.DatabaseReplicaLag = databaseReplicaLagFlag
+	}
+	if Params.RedisAddress == nil {
+		Params.RedisAddress = redisAddressFlag
+	}
+	if Params.RedisPassword == nil {
+		Params.RedisPassword = redisPasswordFlag
+	}
+	if Params.RedisDB == nil {
+		Params.RedisDB = redisDBFlag
+	}
+	if Params.CacheTTL == nil {
+		Params.CacheTTL = cacheTTLFlag
+	}
+	if Params.CacheSize == nil {
+		Params.CacheSize = cacheSizeFlag
+	}
+	if Params.BufferInterval == nil {
+		Params.BufferInterval = bufferIntervalFlag
+	}
+	if Params.BufferSize == nil {
+		Params.BufferSize = bufferSizeFlag
+	}
+	if Params.BufferLimit == nil {
+		Params.BufferLimit = bufferLimitFlag
+	}
+	if Params.BufferMaxFailures == nil {
+		Params.BufferMaxFailures = bufferMaxFailuresFlag
+	}
+	if Params.Tenants == nil {
+		Params.Tenants = tenantsFlag
+	}
+	if Params.TenantJWTKey == nil {
+		Params.TenantJWTKey = tenantJWTKeyFlag
+	}
+	if Params.TenantAnonymous == nil {
+		Params.TenantAnonymous = tenantAnonymousFlag
+	}
+	if Params.TenantQuota == nil {
+		Params.TenantQuota = tenantQuotaFlag
+	}
+	if Params.RateLimit == nil {
+		Params.RateLimit = rateLimitFlag
+	}
+	if Params.RateBurst == nil {
+		Params.RateBurst = rateBurstFlag
+	}
+	if Params.MaxBatchSize == nil {
+		Params.MaxBatchSize = maxBatchSizeFlag
+	}
+	if Params.MaxSourceMetrics == nil {
+		Params.MaxSourceMetrics = maxSourceMetricsFlag
+	}
+	if Params.MaxBodySize == nil {
+		Params.MaxBodySize = maxBodySizeFlag
+	}
+	if Params.MetricNameMaxLength == nil {
+		Params.MetricNameMaxLength = metricNameMaxLengthFlag
+	}
+	if Params.MetricNameReserved == nil {
+		Params.MetricNameReserved = metricNameReservedFlag
+	}
+	if Params.MaxMetrics == nil {
+		Params.MaxMetrics = maxMetricsFlag
+	}
+	if Params.BatchDedupWindow == nil {
+		Params.BatchDedupWindow = batchDedupWindowFlag
+	}
+	if Params.BatchDedupSize == nil {
+		Params.BatchDedupSize = batchDedupSizeFlag
+	}
+	if Params.StatsInterval == nil {
+		Params.StatsInterval = statsIntervalFlag
+	}
+	if Params.DumpAPI == nil {
+		Params.DumpAPI = dumpAPIFlag
+	}
 	if Params.Config == nil {
 		Params.Config = configFlag
 	}
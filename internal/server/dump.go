@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/esafronov/yp-metrics/internal/logger"
+	"github.com/esafronov/yp-metrics/internal/server/config"
+	"github.com/esafronov/yp-metrics/internal/storage"
+)
+
+// runExport executes export subcommand: export <file>, metrics of all tenants are written to file
+func runExport(ctx context.Context, params *config.AppParams, args []string) (err error) {
+	//stdout is not used for dump as server prints build info to it
+	if len(args) == 0 {
+		return errors.New("export file is not set")
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}()
+	s, _, err := newStorage(ctx, params)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, s.Close(ctx))
+	}()
+	exported, err := storage.Export(ctx, s, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("exported %d metrics\r\n", exported)
+	return nil
+}
+
+// runImport executes import subcommand: import [file], dump is read from stdin if file is not set
+func runImport(ctx context.Context, params *config.AppParams, args []string) (err error) {
+	var r io.Reader = os.Stdin
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				logger.Log.Info(err.Error())
+			}
+		}()
+		r = f
+	}
+	s, _, err := newStorage(ctx, params)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, s.Close(ctx))
+	}()
+	//names of dump may have tenant prefixes, so they are not validated
+	imported, err := storage.Import(ctx, s, r, nil)
+	if err != nil {
+		return fmt.Errorf("imported %d metrics: %w", imported, err)
+	}
+	fmt.Printf("imported %d metrics\r\n", imported)
+	return nil
+}
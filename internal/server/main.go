@@ -1,26 +1,46 @@
 // Package server process incomming requests from Agent
+//
+// Server runs http or gRPC server by default, positional argument selects subcommand instead:
+//
+//	server -d <dsn> migrate [up | down [steps] | version]
+//
+//	server export <file>
+//	server import [file]
+//
+// Baseline migration creating metrics table is never reverted. Migration extending metric names
+// is reverted only if there are no names longer than 30 characters.
+//
+// Dump of export and import holds current values of metrics only, server doesn't keep history of values
 package server
 
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/esafronov/yp-metrics/internal/access"
+	"github.com/esafronov/yp-metrics/internal/dedup"
 	pb "github.com/esafronov/yp-metrics/internal/grpc/proto"
 	srv "github.com/esafronov/yp-metrics/internal/grpc/server"
 	"github.com/esafronov/yp-metrics/internal/handlers"
+	"github.com/esafronov/yp-metrics/internal/limits"
 	"github.com/esafronov/yp-metrics/internal/logger"
 	"github.com/esafronov/yp-metrics/internal/pg"
 	"github.com/esafronov/yp-metrics/internal/pprofserv"
+	"github.com/esafronov/yp-metrics/internal/resp"
 	"github.com/esafronov/yp-metrics/internal/server/config"
 	"github.com/esafronov/yp-metrics/internal/signing"
 	"github.com/esafronov/yp-metrics/internal/storage"
+	"github.com/esafronov/yp-metrics/internal/tenant"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 
@@ -45,23 +65,60 @@ func Run() error {
 		zap.String("CryptoCert", *params.CryptoCert),
 		zap.String("TrustedSubnet", *params.TrustedSubnet),
 		zap.Bool("UseGRPC", *params.UseGRPC),
+		zap.Int("DatabaseMaxConns", *params.DatabaseMaxConns),
+		zap.Int("DatabaseConnLifetime", *params.DatabaseConnLifetime),
+		zap.Int("DatabaseStmtCache", *params.DatabaseStmtCache),
+		zap.String("DatabaseReplicaDsn", *params.DatabaseReplicaDsn),
+		zap.Int("DatabaseReplicaLag", *params.DatabaseReplicaLag),
+		zap.String("RedisAddress", *params.RedisAddress),
+		zap.Int("RedisDB", *params.RedisDB),
+		zap.Int("CacheTTL", *params.CacheTTL),
+		zap.Int("CacheSize", *params.CacheSize),
+		zap.Int("BufferInterval", *params.BufferInterval),
+		zap.Int("BufferSize", *params.BufferSize),
+		zap.Int("BufferLimit", *params.BufferLimit),
+		zap.Int("BufferMaxFailures", *params.BufferMaxFailures),
+		zap.Bool("Tenants", *params.Tenants),
+		zap.Bool("TenantAnonymous", *params.TenantAnonymous),
+		zap.Int("TenantQuota", *params.TenantQuota),
+		zap.Float64("RateLimit", *params.RateLimit),
+		zap.Int("RateBurst", *params.RateBurst),
+		zap.Int("MaxBatchSize", *params.MaxBatchSize),
+		zap.Int("MaxSourceMetrics", *params.MaxSourceMetrics),
+		zap.Int("MaxBodySize", *params.MaxBodySize),
+		zap.Int("MetricNameMaxLength", *params.MetricNameMaxLength),
+		zap.String("MetricNameReserved", *params.MetricNameReserved),
+		zap.Int("MaxMetrics", *params.MaxMetrics),
+		zap.Int("StatsInterval", *params.StatsInterval),
+		zap.Bool("DumpAPI", *params.DumpAPI),
 	)
-	err := pg.Connect(params.DatabaseDsn)
+	ctx := context.Background()
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			return runMigrate(ctx, params, args[1:])
+		case "export":
+			return runExport(ctx, params, args[1:])
+		case "import":
+			return runImport(ctx, params, args[1:])
+		default:
+			return fmt.Errorf("unknown command %s", args[0])
+		}
+	}
+	storageInst, stats, err := newStorage(ctx, params)
 	if err != nil {
 		return err
 	}
-	ctx := context.Background()
-	var storageInst storage.Repositories
-	if params.DatabaseDsn != nil && *params.DatabaseDsn == "" {
-		storageInst, err = storage.NewHybridStorage(ctx, params.FileStoragePath, params.StoreInterval, params.Restore)
-		if err != nil {
-			return err
-		}
-	} else {
-		storageInst, err = storage.NewDBStorage(ctx, pg.DB)
-		if err != nil {
-			return err
-		}
+	if *params.StatsInterval > 0 && len(stats) > 0 {
+		statsCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go stats.log(statsCtx, time.Duration(*params.StatsInterval)*time.Second)
+	}
+	if *params.MaxMetrics > 0 {
+		storageInst = storage.NewCardinalityStorage(storageInst, *params.MaxMetrics)
+	}
+	if *params.Tenants {
+		storageInst = storage.NewTenantStorage(storageInst, storage.OptionWithTenantQuota(*params.TenantQuota))
 	}
 	defer func() {
 		err := storageInst.Close(ctx)
@@ -83,6 +140,223 @@ func Run() error {
 	return err
 }
 
+// storageStats functions returning counters of storage layers as log fields
+type storageStats []func() []zap.Field
+
+// log logs counters of storage layers every interval until ctx is done
+func (st storageStats) log(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var fields []zap.Field
+			for _, f := range st {
+				fields = append(fields, f()...)
+			}
+			logger.Log.Info("storage stats", fields...)
+		}
+	}
+}
+
+// newStorage creates storage backend configured by params with metrics of all tenants,
+// counters of its layers are returned for logging
+func newStorage(ctx context.Context, params *config.AppParams) (storage.Repositories, storageStats, error) {
+	//redis is shared by several server instances, so it takes precedence over database and memory
+	if params.RedisAddress != nil && *params.RedisAddress != "" {
+		s, err := newRedisStorage(ctx, params)
+		if err != nil {
+			return nil, nil, err
+		}
+		cached := withCache(params, withBuffer(params, s))
+		return cached, cacheStats(cached), nil
+	}
+	if params.DatabaseDsn != nil && *params.DatabaseDsn == "" {
+		s, err := storage.NewHybridStorage(ctx, params.FileStoragePath, params.StoreInterval, params.Restore)
+		return s, nil, err
+	}
+	s, err := newDBStorage(ctx, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats := storageStats{func() []zap.Field {
+		r := s.RetryStats()
+		return []zap.Field{
+			zap.Int64("DBRetries", r.Retries),
+			zap.Int64("DBRetryRecovered", r.Recovered),
+			zap.Int64("DBRetryExhausted", r.Exhausted),
+		}
+	}}
+	cached := withCache(params, withBuffer(params, s))
+	return cached, append(stats, cacheStats(cached)...), nil
+}
+
+// cacheStats returns counters of cache if storage is cached
+func cacheStats(s storage.Repositories) storageStats {
+	c, ok := s.(*storage.CachedStorage)
+	if !ok {
+		return nil
+	}
+	return storageStats{func() []zap.Field {
+		st := c.CacheStats()
+		return []zap.Field{
+			zap.Int64("CacheHits", st.Hits),
+			zap.Int64("CacheMisses", st.Misses),
+		}
+	}}
+}
+
+// newDBStorage creates DBStorage with primary pool and read replica pools configured by params
+func newDBStorage(ctx context.Context, params *config.AppParams) (*storage.DBStorage, error) {
+	if params.DatabaseDsn == nil {
+		return nil, errors.New("databaseDsn is nil")
+	}
+	var pools []storage.PgxPool
+	closePools := func() {
+		for _, p := range pools {
+			p.Close()
+		}
+	}
+	var replicas []string
+	if params.DatabaseReplicaDsn != nil && *params.DatabaseReplicaDsn != "" {
+		replicas = strings.Split(*params.DatabaseReplicaDsn, ",")
+	}
+	for _, dsn := range append([]string{*params.DatabaseDsn}, replicas...) {
+		pool, err := newPool(ctx, params, strings.TrimSpace(dsn))
+		if err != nil {
+			closePools()
+			return nil, err
+		}
+		pools = append(pools, pool)
+	}
+	var maxLag time.Duration
+	if params.DatabaseReplicaLag != nil {
+		maxLag = time.Duration(*params.DatabaseReplicaLag) * time.Second
+	}
+	s, err := storage.NewDBStorage(ctx, pools[0], storage.OptionWithReplicas(maxLag, pools[1:]...))
+	if err != nil {
+		closePools()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newTenantResolver returns resolver of request tenant, nil if tenants are disabled
+func newTenantResolver(params *config.AppParams) *tenant.Resolver {
+	if params.Tenants == nil || !*params.Tenants {
+		return nil
+	}
+	return tenant.NewResolver(
+		tenant.OptionWithJWTKey(*params.TenantJWTKey),
+		tenant.OptionWithAnonymous(*params.TenantAnonymous),
+	)
+}
+
+// newNameValidator returns validator of metric names configured by params
+func newNameValidator(params *config.AppParams) *storage.NameValidator {
+	maxLength := *params.MetricNameMaxLength
+	if *params.Tenants {
+		//names of tenants are stored with "<tenant>/" prefix
+		maxLength = min(maxLength, storage.MaxMetricNameLength-tenant.MaxIDLength-1)
+	}
+	var reserved []string
+	if *params.MetricNameReserved != "" {
+		reserved = strings.Split(*params.MetricNameReserved, ",")
+	}
+	return storage.NewNameValidator(
+		storage.OptionWithMaxNameLength(maxLength),
+		storage.OptionWithReservedPrefixes(reserved...),
+	)
+}
+
+// newLimiter returns limiter of updates configured by params, nil if nothing is limited
+func newLimiter(params *config.AppParams) *limits.Limiter {
+	if *params.RateLimit <= 0 && *params.MaxBatchSize <= 0 && *params.MaxSourceMetrics <= 0 && *params.MaxBodySize <= 0 {
+		return nil
+	}
+	return limits.NewLimiter(
+		limits.OptionWithRate(*params.RateLimit, *params.RateBurst),
+		limits.OptionWithMaxBatch(*params.MaxBatchSize),
+		limits.OptionWithMaxNames(*params.MaxSourceMetrics),
+		limits.OptionWithMaxBody(int64(*params.MaxBodySize)),
+		limits.OptionWithTrustedSubnet(*params.TrustedSubnet),
+	)
+}
+
+// newDedupWindow returns window of applied batch ids configured by params, nil if deduplication is disabled
+func newDedupWindow(params *config.AppParams) *dedup.Window {
+	if *params.BatchDedupWindow <= 0 {
+		return nil
+	}
+	return dedup.NewWindow(time.Duration(*params.BatchDedupWindow)*time.Second, *params.BatchDedupSize)
+}
+
+// withBuffer wraps remote storage with write-behind buffer if flush interval is set
+func withBuffer(params *config.AppParams, s storage.Repositories) storage.Repositories {
+	if params.BufferInterval == nil || *params.BufferInterval <= 0 {
+		return s
+	}
+	var opts []func(s *storage.BufferedStorage)
+	if params.BufferSize != nil {
+		opts = append(opts, storage.OptionWithBufferFlushSize(*params.BufferSize))
+	}
+	if params.BufferLimit != nil {
+		opts = append(opts, storage.OptionWithBufferLimit(*params.BufferLimit))
+	}
+	if params.BufferMaxFailures != nil {
+		opts = append(opts, storage.OptionWithBufferMaxFailures(*params.BufferMaxFailures))
+	}
+	return storage.NewBufferedStorage(s, time.Duration(*params.BufferInterval)*time.Millisecond, opts...)
+}
+
+// withCache wraps remote storage with in-memory cache if cache ttl is set
+func withCache(params *config.AppParams, s storage.Repositories) storage.Repositories {
+	if params.CacheTTL == nil || *params.CacheTTL <= 0 {
+		return s
+	}
+	var opts []func(s *storage.CachedStorage)
+	if params.CacheSize != nil {
+		opts = append(opts, storage.OptionWithCacheSize(*params.CacheSize))
+	}
+	return storage.NewCachedStorage(s, time.Duration(*params.CacheTTL)*time.Millisecond, opts...)
+}
+
+// newRedisStorage creates RedisStorage and checks redis is reachable
+func newRedisStorage(ctx context.Context, params *config.AppParams) (*storage.RedisStorage, error) {
+	var opts []func(c *resp.Client)
+	if params.RedisPassword != nil && *params.RedisPassword != "" {
+		opts = append(opts, resp.OptionWithPassword(*params.RedisPassword))
+	}
+	if params.RedisDB != nil {
+		opts = append(opts, resp.OptionWithDB(*params.RedisDB))
+	}
+	s := storage.NewRedisStorage(resp.NewClient(*params.RedisAddress, opts...))
+	if err := s.Ping(ctx); err != nil {
+		if err := s.Close(ctx); err != nil {
+			logger.Log.Info(err.Error())
+		}
+		return nil, fmt.Errorf("redis is not available: %w", err)
+	}
+	return s, nil
+}
+
+// newPool creates db connection pool for dsn with pool settings from params
+func newPool(ctx context.Context, params *config.AppParams, dsn string) (*pgxpool.Pool, error) {
+	cfg := pg.Config{Dsn: dsn}
+	if params.DatabaseMaxConns != nil {
+		cfg.MaxConns = int32(*params.DatabaseMaxConns)
+	}
+	if params.DatabaseConnLifetime != nil {
+		cfg.MaxConnLifetime = time.Duration(*params.DatabaseConnLifetime) * time.Second
+	}
+	if params.DatabaseStmtCache != nil {
+		cfg.StatementCacheCapacity = *params.DatabaseStmtCache
+	}
+	return pg.NewPool(ctx, cfg)
+}
+
 func runGRPCServer(params *config.AppParams, storageInst storage.Repositories) error {
 	if params.Address == nil {
 		return errors.New("serverAddress is nil")
@@ -103,17 +377,34 @@ func runGRPCServer(params *config.AppParams, storageInst storage.Repositories) e
 		}
 	}
 
-	// создаём gRPC-сервер без зарегистрированной службы
-	server := grpc.NewServer(
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		logger.UnaryLoggerInterceptor,
+		access.UnaryValidateIpInterceptor(*params.TrustedSubnet),
+		signing.UnaryValidateSignatureInterceptor(*params.SecretKey),
+	}
+	var streamInterceptors []grpc.StreamServerInterceptor
+	if resolver := newTenantResolver(params); resolver != nil {
+		unaryInterceptors = append(unaryInterceptors, tenant.UnaryServerInterceptor(resolver))
+		streamInterceptors = append(streamInterceptors, tenant.StreamServerInterceptor(resolver))
+	}
+	serverOpts := []grpc.ServerOption{
 		//устанавливаем credentials
 		grpc.Creds(creds),
+	}
+	//limits are checked after tenant is resolved
+	if limiter := newLimiter(params); limiter != nil {
+		unaryInterceptors = append(unaryInterceptors, limits.UnaryServerInterceptor(limiter))
+		if limiter.MaxBody() > 0 {
+			serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(int(limiter.MaxBody())))
+		}
+	}
+
+	// создаём gRPC-сервер без зарегистрированной службы
+	server := grpc.NewServer(append(serverOpts,
 		//цепочку интерсептеров
-		grpc.ChainUnaryInterceptor(
-			logger.UnaryLoggerInterceptor,
-			access.UnaryValidateIpInterceptor(*params.TrustedSubnet),
-			signing.UnaryValidateSignatureInterceptor(*params.SecretKey),
-		),
-	)
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	)...)
 
 	//создаем метрик сервис
 	service := srv.NewMetricsServer(
@@ -121,6 +412,8 @@ func runGRPCServer(params *config.AppParams, storageInst storage.Repositories) e
 		srv.OptionWithSecretKey(*params.SecretKey),
 		srv.OptionWithCryptoKey(*params.CryptoKey),
 		srv.OptionWithTrustedSubnet(*params.TrustedSubnet),
+		srv.OptionWithNameValidator(newNameValidator(params)),
+		srv.OptionWithDedup(newDedupWindow(params)),
 	)
 
 	// регистрируем сервис на сервере
@@ -150,6 +443,11 @@ func runHTTPServer(params *config.AppParams, storageInst storage.Repositories) e
 		handlers.OptionWithSecretKey(*params.SecretKey),
 		handlers.OptionWithCryptoKey(*params.CryptoKey),
 		handlers.OptionWithTrustedSubnet(*params.TrustedSubnet),
+		handlers.OptionWithTenantResolver(newTenantResolver(params)),
+		handlers.OptionWithLimiter(newLimiter(params)),
+		handlers.OptionWithNameValidator(newNameValidator(params)),
+		handlers.OptionWithDedup(newDedupWindow(params)),
+		handlers.OptionWithDumpAPI(*params.DumpAPI),
 	)
 	if params.Address == nil {
 		return errors.New("serverAddress is nil")
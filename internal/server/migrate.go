@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/esafronov/yp-metrics/internal/server/config"
+	"github.com/esafronov/yp-metrics/internal/storage"
+)
+
+// runMigrate executes migrate subcommand: migrate [up | down [steps] | version]
+func runMigrate(ctx context.Context, params *config.AppParams, args []string) error {
+	if params.DatabaseDsn == nil || *params.DatabaseDsn == "" {
+		return errors.New("database dsn is not set")
+	}
+	pool, err := newPool(ctx, params, *params.DatabaseDsn)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	m := storage.NewMigrator(pool)
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "up":
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\r\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("wrong number of steps %s", args[1])
+			}
+			steps = n
+		}
+		reverted, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d migrations\r\n", reverted)
+	case "version":
+		version, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d\r\n", version)
+	default:
+		return fmt.Errorf("unknown migrate action %s, use up, down [steps] or version", action)
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/logger"
+	"go.uber.org/zap"
+)
+
+// defaultBufferFlushSize number of pending metrics which triggers flush
+const defaultBufferFlushSize int = 1000
+
+// defaultBufferMaxFailures number of consecutive failed flushes after which pending metrics are dropped
+const defaultBufferMaxFailures int = 30
+
+// ErrBufferFull is returned by writes when pending metrics reached limit and flush failed
+var ErrBufferFull = errors.New("write buffer is full")
+
+// BufferedStorage accumulates writes in memory and flushes them into wrapped storage with one BatchUpdate
+// every interval or when flush size is reached. Counter deltas are summed, last gauge value wins.
+// Reads return stored values with pending and in-flight writes applied, they don't wait for flush
+// unless they read counter being flushed, stored value of it is ambiguous until flush is done
+type BufferedStorage struct {
+	Repositories                        //wrapped storage, receives flushed batches
+	pending      map[MetricName]Metrics //writes not flushed yet
+	inflight     map[MetricName]Metrics //writes being flushed, nil if flush is not in progress
+	flushed      chan struct{}          //closed when flush in progress is done
+	flushCh      chan struct{}          //signal to flush before interval
+	done         chan struct{}
+	wg           sync.WaitGroup
+	mu           sync.Mutex   //guards pending, inflight, flushed and seq
+	flushMu      sync.Mutex   //one flush at a time, guards failures
+	setMu        sync.RWMutex //BatchSet holds it exclusively, so reads never see set half applied
+	seq          uint64       //incremented on start and end of flush, odd while flush is in progress
+	interval     time.Duration
+	flushSize    int
+	limit        int //max pending metrics, writer flushes synchronously when it is reached
+	maxFailures  int //consecutive failed flushes after which pending metrics are dropped
+	failures     int
+	closeOnce    sync.Once
+}
+
+// OptionWithBufferFlushSize option function to configure number of pending metrics which triggers flush
+func OptionWithBufferFlushSize(size int) func(s *BufferedStorage) {
+	return func(s *BufferedStorage) {
+		if size > 0 {
+			s.flushSize = size
+		}
+	}
+}
+
+// OptionWithBufferLimit option function to configure max number of pending metrics
+func OptionWithBufferLimit(limit int) func(s *BufferedStorage) {
+	return func(s *BufferedStorage) {
+		if limit > 0 {
+			s.limit = limit
+		}
+	}
+}
+
+// OptionWithBufferMaxFailures option function to configure number of consecutive failed flushes
+// after which pending metrics are dropped
+func OptionWithBufferMaxFailures(n int) func(s *BufferedStorage) {
+	return func(s *BufferedStorage) {
+		if n > 0 {
+			s.maxFailures = n
+		}
+	}
+}
+
+// NewBufferedStorage is factory method, it starts background flushing every interval
+func NewBufferedStorage(next Repositories, interval time.Duration, opts ...func(s *BufferedStorage)) *BufferedStorage {
+	s := &BufferedStorage{
+		Repositories: next,
+		pending:      make(map[MetricName]Metrics),
+		flushCh:      make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		interval:     interval,
+		flushSize:    defaultBufferFlushSize,
+		maxFailures:  defaultBufferMaxFailures,
+	}
+	for _, f := range opts {
+		f(s)
+	}
+	if s.limit == 0 {
+		s.limit = s.flushSize * 10
+	}
+	s.limit = max(s.limit, s.flushSize)
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// run flushes pending metrics by timer or by signal of writer
+func (s *BufferedStorage) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.flushCh:
+		}
+		if err := s.Flush(context.Background()); err != nil {
+			logger.Log.Info("buffer flush failed", zap.Error(err))
+		}
+	}
+}
+
+func (s *BufferedStorage) Get(ctx context.Context, key MetricName) (Metric, error) {
+	s.setMu.RLock()
+	defer s.setMu.RUnlock()
+	for {
+		seq, err := s.waitFlush(ctx, func(inflight map[MetricName]Metrics) bool {
+			return isCounter(inflight[key])
+		})
+		if err != nil {
+			return nil, err
+		}
+		m, err := s.Repositories.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		if s.seq != seq {
+			//flush started or finished while reading, stored value may include writes of buffer
+			s.mu.Unlock()
+			continue
+		}
+		p, ok := s.bufferedLocked(key)
+		s.mu.Unlock()
+		if ok {
+			return applyPending(m, p), nil
+		}
+		if m == nil {
+			return nil, nil
+		}
+		//copy, caller updates returned metric and it must not change stored one
+		return cloneMetric(m), nil
+	}
+}
+
+func (s *BufferedStorage) Insert(ctx context.Context, key MetricName, m Metric) error {
+	if m == nil {
+		return fmt.Errorf("metric is nil")
+	}
+	return s.add(ctx, []Metrics{{ID: string(key), ActualValue: m.GetValue()}})
+}
+
+func (s *BufferedStorage) Update(ctx context.Context, key MetricName, v interface{}, metric Metric) error {
+	if v == nil {
+		return fmt.Errorf("value is nil")
+	}
+	if err := s.add(ctx, []Metrics{{ID: string(key), ActualValue: v}}); err != nil {
+		return err
+	}
+	metric.UpdateValue(v)
+	return nil
+}
+
+func (s *BufferedStorage) BatchUpdate(ctx context.Context, metrics []Metrics) error {
+	return s.add(ctx, metrics)
+}
+
+func (s *BufferedStorage) GetAll(ctx context.Context) (map[MetricName]Metric, error) {
+	s.setMu.RLock()
+	defer s.setMu.RUnlock()
+	for {
+		seq, err := s.waitFlush(ctx, func(inflight map[MetricName]Metrics) bool {
+			for _, p := range inflight {
+				if isCounter(p) {
+					return true
+				}
+			}
+			return false
+		})
+		if err != nil {
+			return nil, err
+		}
+		stored, err := s.Repositories.GetAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		if s.seq != seq {
+			s.mu.Unlock()
+			continue
+		}
+		//wrapped storage may return its own map, so result is built in new one
+		metrics := make(map[MetricName]Metric, len(stored))
+		for key, m := range stored {
+			metrics[key] = cloneMetric(m)
+		}
+		for key, p := range s.inflight {
+			metrics[key] = applyPending(metrics[key], p)
+		}
+		for key, p := range s.pending {
+			metrics[key] = applyPending(metrics[key], p)
+		}
+		s.mu.Unlock()
+		return metrics, nil
+	}
+}
+
+// waitFlush waits for flush in progress if read needs its metrics, returns sequence of flush state read starts with
+func (s *BufferedStorage) waitFlush(ctx context.Context, needs func(inflight map[MetricName]Metrics) bool) (uint64, error) {
+	for {
+		s.mu.Lock()
+		seq, flushed := s.seq, s.flushed
+		wait := s.inflight != nil && needs(s.inflight)
+		s.mu.Unlock()
+		if !wait {
+			return seq, nil
+		}
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// bufferedLocked returns in-flight and pending writes of metric merged, caller holds lock
+func (s *BufferedStorage) bufferedLocked(key MetricName) (Metrics, bool) {
+	p, ok := s.inflight[key]
+	if newer, pending := s.pending[key]; pending {
+		if ok {
+			return mergePending(p, newer), true
+		}
+		return newer, true
+	}
+	return p, ok
+}
+
+// Flush writes pending metrics into wrapped storage, they are kept pending if write fails.
+// Pending metrics are dropped after max failures, so writes are not rejected as buffer full forever
+func (s *BufferedStorage) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.mu.Lock()
+	if len(s.pending) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	batch := s.pending
+	s.pending = make(map[MetricName]Metrics, len(batch))
+	s.inflight = batch
+	s.flushed = make(chan struct{})
+	s.seq++
+	s.mu.Unlock()
+
+	metrics := make([]Metrics, 0, len(batch))
+	for _, p := range batch {
+		metrics = append(metrics, p)
+	}
+	err := s.Repositories.BatchUpdate(ctx, metrics)
+	dropped := false
+	if err == nil {
+		s.failures = 0
+	} else {
+		s.failures++
+		dropped = s.failures >= s.maxFailures
+	}
+	s.mu.Lock()
+	if err != nil && !dropped {
+		s.restoreLocked(batch)
+	}
+	s.inflight = nil
+	s.seq++
+	close(s.flushed)
+	s.mu.Unlock()
+	if dropped {
+		s.failures = 0
+		logger.Log.Error("buffered metrics are dropped", zap.Int("metrics", len(batch)), zap.Error(err))
+		return fmt.Errorf("%d metrics are dropped after %d failed flushes: %w", len(batch), s.maxFailures, err)
+	}
+	return err
+}
+
+// BatchSet writes metrics into wrapped storage at once, pending writes of them are dropped
+// as they were made before set. Reads wait until set is done
+func (s *BufferedStorage) BatchSet(ctx context.Context, metrics []Metrics) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.setMu.Lock()
+	defer s.setMu.Unlock()
+	s.mu.Lock()
+	dropped := make(map[MetricName]Metrics)
+	for _, m := range metrics {
+		key := MetricName(m.ID)
+		if p, ok := s.pending[key]; ok {
+			dropped[key] = p
+			delete(s.pending, key)
+		}
+	}
+	s.mu.Unlock()
+	err := s.Repositories.BatchSet(ctx, metrics)
+	if err == nil {
+		return nil
+	}
+	s.mu.Lock()
+	s.restoreLocked(dropped)
+	s.mu.Unlock()
+	return err
+}
+
+// restoreLocked returns metrics taken from buffer under writes made while they were written, caller holds lock
+func (s *BufferedStorage) restoreLocked(batch map[MetricName]Metrics) {
+	for key, p := range batch {
+		if newer, ok := s.pending[key]; ok {
+			s.pending[key] = mergePending(p, newer)
+			continue
+		}
+		s.pending[key] = p
+	}
+}
+
+// Close stops background flushing, flushes pending metrics and closes wrapped storage
+func (s *BufferedStorage) Close(ctx context.Context) error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		err = s.Flush(ctx)
+		if closeErr := s.Repositories.Close(ctx); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	})
+	return err
+}
+
+// add merges metrics into pending ones, triggers flush when flush size is reached
+// and flushes synchronously when buffer is full. Limit may be exceeded by one batch
+func (s *BufferedStorage) add(ctx context.Context, metrics []Metrics) error {
+	for _, m := range metrics {
+		switch m.ActualValue.(type) {
+		case int64, float64:
+		default:
+			return fmt.Errorf("metric type is unknown")
+		}
+	}
+	if s.isFull(metrics) {
+		if err := s.Flush(ctx); err != nil {
+			return fmt.Errorf("%w: %w", ErrBufferFull, err)
+		}
+	}
+	s.mu.Lock()
+	for _, m := range metrics {
+		key := MetricName(m.ID)
+		p := Metrics{ID: m.ID, ActualValue: m.ActualValue}
+		if prev, ok := s.pending[key]; ok {
+			p = mergePending(prev, p)
+		}
+		s.pending[key] = p
+	}
+	size := len(s.pending)
+	s.mu.Unlock()
+	if size >= s.flushSize {
+		select {
+		case s.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// isFull reports whether pending metrics reached limit and metrics add new ones,
+// writes of already pending metrics don't take more memory and are always accepted
+func (s *BufferedStorage) isFull(metrics []Metrics) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) < s.limit {
+		return false
+	}
+	for _, m := range metrics {
+		if _, ok := s.pending[MetricName(m.ID)]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isCounter reports whether buffered write is counter delta
+func isCounter(p Metrics) bool {
+	_, ok := p.ActualValue.(int64)
+	return ok
+}
+
+// mergePending combines older and newer write of the same metric
+func mergePending(older, newer Metrics) Metrics {
+	if prev, ok := older.ActualValue.(int64); ok {
+		if delta, ok := newer.ActualValue.(int64); ok {
+			newer.ActualValue = prev + delta
+		}
+	}
+	return newer
+}
+
+// applyPending returns stored metric with pending write applied, stored metric is not changed
+func applyPending(m Metric, p Metrics) Metric {
+	switch v := p.ActualValue.(type) {
+	case int64:
+		if m != nil {
+			if stored, ok := m.GetValue().(int64); ok {
+				return NewMetricCounter(stored + v)
+			}
+		}
+		return NewMetricCounter(v)
+	case float64:
+		return NewMetricGauge(v)
+	}
+	return m
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchRecorder memory storage which records batch updates and fails them on demand
+type batchRecorder struct {
+	*MemStorage
+	err     error
+	release chan struct{} //batch update waits for it if set
+	batches [][]Metrics
+	mu      sync.Mutex
+	closed  bool
+}
+
+func (r *batchRecorder) BatchUpdate(ctx context.Context, metrics []Metrics) error {
+	if r.release != nil {
+		<-r.release
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.batches = append(r.batches, metrics)
+	return r.MemStorage.BatchUpdate(ctx, metrics)
+}
+
+func (r *batchRecorder) Close(ctx context.Context) error {
+	r.closed = true
+	return nil
+}
+
+func (r *batchRecorder) setErr(err error) {
+	r.mu.Lock()
+	r.err = err
+	r.mu.Unlock()
+}
+
+func (r *batchRecorder) batchCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.batches)
+}
+
+func TestBufferedStorage_PendingReads(t *testing.T) {
+	ctx := context.Background()
+	rec := &batchRecorder{MemStorage: NewMemStorageWithValues(map[MetricName]Metric{
+		"test": NewMetricCounter(int64(10)),
+	})}
+	s := NewBufferedStorage(rec, time.Hour)
+	defer func() {
+		require.NoError(t, s.Close(ctx))
+	}()
+
+	counter, err := s.Get(ctx, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.Update(ctx, "test", int64(2), counter))
+	require.Equal(t, int64(12), counter.GetValue())
+	require.NoError(t, s.Insert(ctx, "gtest", NewMetricGauge(float64(1))))
+	require.NoError(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "test", ActualValue: int64(3)},
+		{ID: "gtest", ActualValue: float64(2)},
+	}))
+
+	//nothing is written yet, stored metric is not changed by caller
+	require.Equal(t, 0, rec.batchCount())
+	require.Equal(t, NewMetricCounter(int64(10)), storedMetric(t, rec, "test"))
+
+	m, err := s.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(15)), m)
+	all, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[MetricName]Metric{
+		"test":  NewMetricCounter(int64(15)),
+		"gtest": NewMetricGauge(float64(2)),
+	}, all)
+
+	require.NoError(t, s.Flush(ctx))
+	require.Equal(t, 1, rec.batchCount())
+	require.Len(t, rec.batches[0], 2, "записи одной метрики должны быть объединены")
+	require.Equal(t, NewMetricCounter(int64(15)), storedMetric(t, rec, "test"))
+	m, err = s.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(15)), m)
+}
+
+func TestBufferedStorage_FlushTriggers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("flush size", func(t *testing.T) {
+		rec := &batchRecorder{MemStorage: NewMemStorage()}
+		s := NewBufferedStorage(rec, time.Hour, OptionWithBufferFlushSize(2))
+		defer func() {
+			require.NoError(t, s.Close(ctx))
+		}()
+		require.NoError(t, s.Insert(ctx, "a", NewMetricCounter(int64(1))))
+		require.Equal(t, 0, rec.batchCount())
+		require.NoError(t, s.Insert(ctx, "b", NewMetricCounter(int64(1))))
+		require.Eventually(t, func() bool {
+			return rec.batchCount() == 1
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		rec := &batchRecorder{MemStorage: NewMemStorage()}
+		s := NewBufferedStorage(rec, 10*time.Millisecond)
+		defer func() {
+			require.NoError(t, s.Close(ctx))
+		}()
+		require.NoError(t, s.Insert(ctx, "a", NewMetricCounter(int64(1))))
+		require.Eventually(t, func() bool {
+			return rec.batchCount() == 1
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		rec := &batchRecorder{MemStorage: NewMemStorage()}
+		s := NewBufferedStorage(rec, time.Hour)
+		require.NoError(t, s.Insert(ctx, "a", NewMetricGauge(float64(1))))
+		require.NoError(t, s.Close(ctx))
+		require.Equal(t, 1, rec.batchCount())
+		require.True(t, rec.closed)
+		require.Equal(t, NewMetricGauge(float64(1)), storedMetric(t, rec, "a"))
+	})
+}
+
+func TestBufferedStorage_FlushFailure(t *testing.T) {
+	ctx := context.Background()
+	errDB := errors.New("db is down")
+	rec := &batchRecorder{MemStorage: NewMemStorage()}
+	s := NewBufferedStorage(rec, time.Hour, OptionWithBufferFlushSize(1), OptionWithBufferLimit(2))
+	rec.setErr(errDB)
+	//background flush is triggered and fails
+	require.NoError(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "a", ActualValue: int64(1)},
+		{ID: "b", ActualValue: int64(1)},
+	}))
+	require.NoError(t, s.Update(ctx, "a", int64(1), NewMetricCounter(int64(0))))
+
+	//buffer is full, synchronous flush fails and write is rejected
+	require.ErrorIs(t, s.Insert(ctx, "c", NewMetricCounter(int64(1))), ErrBufferFull)
+	m, err := s.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(2)), m, "неотправленные значения должны сохраниться")
+
+	rec.setErr(nil)
+	require.NoError(t, s.Close(ctx))
+	require.Equal(t, NewMetricCounter(int64(2)), storedMetric(t, rec, "a"))
+	require.Equal(t, NewMetricCounter(int64(1)), storedMetric(t, rec, "b"))
+	require.Nil(t, storedMetric(t, rec, "c"))
+}
+
+func TestBufferedStorage_BatchSet(t *testing.T) {
+	ctx := context.Background()
+	next := &batchRecorder{MemStorage: NewMemStorageWithValues(map[MetricName]Metric{
+		"k": NewMetricCounter(int64(10)),
+	})}
+	s := NewBufferedStorage(next, time.Hour)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close(ctx))
+	})
+
+	require.NoError(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "k", ActualValue: int64(5)},
+		{ID: "other", ActualValue: int64(1)},
+	}))
+	//pending delta of k is overwritten by set, other one stays pending
+	require.NoError(t, s.BatchSet(ctx, []Metrics{{ID: "k", ActualValue: int64(2)}}))
+	require.Equal(t, NewMetricCounter(int64(2)), storedMetric(t, next, "k"))
+	m, err := s.Get(ctx, "k")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(2)), m)
+	require.NoError(t, s.Flush(ctx))
+	require.Equal(t, NewMetricCounter(int64(2)), storedMetric(t, next, "k"))
+	require.Equal(t, NewMetricCounter(int64(1)), storedMetric(t, next, "other"))
+}
+
+func TestBufferedStorage_ReadsDuringFlush(t *testing.T) {
+	ctx := context.Background()
+	rec := &batchRecorder{
+		MemStorage: NewMemStorageWithValues(map[MetricName]Metric{
+			"g":     NewMetricGauge(float64(1)),
+			"c":     NewMetricCounter(int64(10)),
+			"other": NewMetricCounter(int64(3)),
+		}),
+		release: make(chan struct{}),
+	}
+	s := NewBufferedStorage(rec, time.Hour)
+	require.NoError(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "g", ActualValue: float64(2)},
+		{ID: "c", ActualValue: int64(5)},
+	}))
+	flushErr := make(chan error)
+	go func() {
+		flushErr <- s.Flush(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.inflight != nil
+	}, time.Second, time.Millisecond)
+
+	//metrics not being flushed and gauges are read without waiting for flush
+	m, err := s.Get(ctx, "other")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(3)), m)
+	m, err = s.Get(ctx, "g")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricGauge(float64(2)), m)
+
+	//counter being flushed is read after flush
+	counter := make(chan Metric)
+	go func() {
+		m, err := s.Get(ctx, "c")
+		assert.NoError(t, err)
+		counter <- m
+	}()
+	select {
+	case <-counter:
+		t.Fatal("counter is read before flush is done")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(rec.release)
+	require.Equal(t, NewMetricCounter(int64(15)), <-counter)
+	require.NoError(t, <-flushErr)
+	require.NoError(t, s.Close(ctx))
+}
+
+func TestBufferedStorage_DropAfterMaxFailures(t *testing.T) {
+	ctx := context.Background()
+	rec := &batchRecorder{MemStorage: NewMemStorage()}
+	s := NewBufferedStorage(rec, time.Hour, OptionWithBufferMaxFailures(2))
+	rec.setErr(errors.New("db is down"))
+	require.NoError(t, s.Update(ctx, "a", int64(1), NewMetricCounter(int64(0))))
+
+	require.Error(t, s.Flush(ctx))
+	m, err := s.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(1)), m)
+
+	require.ErrorContains(t, s.Flush(ctx), "1 metrics are dropped after 2 failed flushes")
+	m, err = s.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Nil(t, m)
+
+	rec.setErr(nil)
+	require.NoError(t, s.Close(ctx))
+}
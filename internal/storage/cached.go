@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultCacheSize max number of cached metrics
+const defaultCacheSize int = 10000
+
+// cacheEntry cached metric and moment it becomes stale
+type cacheEntry struct {
+	expires time.Time
+	m       Metric
+	key     MetricName
+}
+
+// CacheStats counters of CachedStorage lookups
+type CacheStats struct {
+	Hits   int64 //Get served from cache
+	Misses int64 //Get passed to wrapped storage
+}
+
+// CachedStorage keeps recently read metrics in memory in front of slower storage.
+// Writes go through to wrapped storage. Gauge is cached after write because it is replaced by value,
+// counter is evicted because other servers sharing storage may have added to it.
+// Changes made by other servers become visible after ttl at the latest.
+// Entries are ordered by expiration, entry expiring first is evicted when cache is full
+type CachedStorage struct {
+	Repositories //wrapped storage, receives all writes
+	entries      map[MetricName]*list.Element
+	order        *list.List //entries from last cached to first cached, so from last to first expiring
+	now          func() time.Time
+	hits         atomic.Int64
+	misses       atomic.Int64
+	mu           sync.RWMutex
+	ttl          time.Duration
+	size         int
+	writes       uint64 //incremented on every eviction, guarded by mu
+}
+
+// OptionWithCacheSize option function to configure max number of cached metrics
+func OptionWithCacheSize(size int) func(s *CachedStorage) {
+	return func(s *CachedStorage) {
+		if size > 0 {
+			s.size = size
+		}
+	}
+}
+
+// NewCachedStorage is factory method, ttl limits how long cached value is served
+func NewCachedStorage(next Repositories, ttl time.Duration, opts ...func(s *CachedStorage)) *CachedStorage {
+	s := &CachedStorage{
+		Repositories: next,
+		entries:      make(map[MetricName]*list.Element),
+		order:        list.New(),
+		now:          time.Now,
+		ttl:          ttl,
+		size:         defaultCacheSize,
+	}
+	for _, f := range opts {
+		f(s)
+	}
+	return s
+}
+
+// Get returns cached metric if it is not stale, otherwise reads wrapped storage and caches result
+func (s *CachedStorage) Get(ctx context.Context, key MetricName) (Metric, error) {
+	s.mu.RLock()
+	var e cacheEntry
+	el, ok := s.entries[key]
+	if ok {
+		e = *el.Value.(*cacheEntry)
+	}
+	writes := s.writes
+	s.mu.RUnlock()
+	if ok && s.now().Before(e.expires) {
+		s.hits.Add(1)
+		return cloneMetric(e.m), nil
+	}
+	s.misses.Add(1)
+	m, err := s.Repositories.Get(ctx, key)
+	if err != nil || m == nil {
+		return m, err
+	}
+	//value read before concurrent write must not get into cache after its eviction
+	s.mu.Lock()
+	if s.writes == writes {
+		s.setLocked(key, m)
+	}
+	s.mu.Unlock()
+	return m, nil
+}
+
+func (s *CachedStorage) Insert(ctx context.Context, key MetricName, m Metric) error {
+	//evict before write, so failed write doesn't leave old value in cache
+	s.evict(key)
+	err := s.Repositories.Insert(ctx, key, m)
+	var written Metric
+	if _, ok := m.(*MetricGauge); ok && err == nil {
+		written = m
+	}
+	s.written(key, written)
+	return err
+}
+
+func (s *CachedStorage) Update(ctx context.Context, key MetricName, v interface{}, metric Metric) error {
+	s.evict(key)
+	err := s.Repositories.Update(ctx, key, v, metric)
+	var written Metric
+	//gauge value is replaced by v, whatever metric held before
+	if _, ok := v.(float64); ok && err == nil {
+		written = NewMetricGauge(v)
+	}
+	s.written(key, written)
+	return err
+}
+
+func (s *CachedStorage) BatchUpdate(ctx context.Context, metrics []Metrics) error {
+	s.evictBatch(metrics)
+	defer s.evictBatch(metrics)
+	return s.Repositories.BatchUpdate(ctx, metrics)
+}
+
+func (s *CachedStorage) BatchSet(ctx context.Context, metrics []Metrics) error {
+	s.evictBatch(metrics)
+	defer s.evictBatch(metrics)
+	return s.Repositories.BatchSet(ctx, metrics)
+}
+
+// evictBatch drops cached metrics of batch
+func (s *CachedStorage) evictBatch(metrics []Metrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range metrics {
+		s.removeLocked(MetricName(m.ID))
+	}
+	s.writes++
+}
+
+// GetAll reads wrapped storage, result refreshes cache
+func (s *CachedStorage) GetAll(ctx context.Context) (map[MetricName]Metric, error) {
+	s.mu.RLock()
+	writes := s.writes
+	s.mu.RUnlock()
+	metrics, err := s.Repositories.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.writes == writes {
+		for key, m := range metrics {
+			s.setLocked(key, m)
+		}
+	}
+	s.mu.Unlock()
+	return metrics, nil
+}
+
+// CacheStats returns snapshot of cache counters
+func (s *CachedStorage) CacheStats() CacheStats {
+	return CacheStats{
+		Hits:   s.hits.Load(),
+		Misses: s.misses.Load(),
+	}
+}
+
+// set caches copy of metric, entry expiring first is dropped when cache is full
+func (s *CachedStorage) set(key MetricName, m Metric) {
+	s.mu.Lock()
+	s.setLocked(key, m)
+	s.mu.Unlock()
+}
+
+// setLocked is set for caller holding lock
+func (s *CachedStorage) setLocked(key MetricName, m Metric) {
+	e := &cacheEntry{key: key, m: cloneMetric(m), expires: s.now().Add(s.ttl)}
+	//ttl is the same for all entries, so refreshed entry expires last
+	if el, ok := s.entries[key]; ok {
+		el.Value = e
+		s.order.MoveToFront(el)
+		return
+	}
+	if len(s.entries) >= s.size {
+		if back := s.order.Back(); back != nil {
+			s.removeLocked(back.Value.(*cacheEntry).key)
+		}
+	}
+	s.entries[key] = s.order.PushFront(e)
+}
+
+// removeLocked drops cached metric, caller holds lock
+func (s *CachedStorage) removeLocked(key MetricName) {
+	if el, ok := s.entries[key]; ok {
+		s.order.Remove(el)
+		delete(s.entries, key)
+	}
+}
+
+// written caches metric m after write or evicts it if m is nil.
+// Value read by concurrent Get before write is finished is evicted too
+func (s *CachedStorage) written(key MetricName, m Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if m == nil {
+		s.removeLocked(key)
+		return
+	}
+	s.setLocked(key, m)
+}
+
+func (s *CachedStorage) evict(key MetricName) {
+	s.mu.Lock()
+	s.removeLocked(key)
+	s.writes++
+	s.mu.Unlock()
+}
+
+// cloneMetric copies metric, so callers updating returned metric don't change cached one
+func cloneMetric(m Metric) Metric {
+	switch v := m.GetValue().(type) {
+	case int64:
+		return NewMetricCounter(v)
+	case float64:
+		return NewMetricGauge(v)
+	}
+	return m
+}
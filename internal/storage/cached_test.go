@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock manual clock for cache expiration
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newCachedStorage(next Repositories, ttl time.Duration, clock *fakeClock) *CachedStorage {
+	s := NewCachedStorage(next, ttl)
+	s.now = clock.now
+	return s
+}
+
+func TestCachedStorage_Get(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Now()}
+	mem := NewMemStorageWithValues(map[MetricName]Metric{
+		"test": NewMetricCounter(int64(1)),
+	})
+	s := newCachedStorage(mem, time.Second, clock)
+
+	m, err := s.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(1)), m)
+	//returned metric is copy, its change doesn't affect cache
+	m.UpdateValue(int64(10))
+	m, err = s.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(1)), m)
+	require.Equal(t, CacheStats{Hits: 1, Misses: 1}, s.CacheStats())
+
+	//missing metric is not cached
+	m, err = s.Get(ctx, "unknown")
+	require.NoError(t, err)
+	require.Nil(t, m)
+	require.Equal(t, CacheStats{Hits: 1, Misses: 2}, s.CacheStats())
+
+	//stale entry is read again
+	require.NoError(t, mem.Insert(ctx, "test", NewMetricCounter(int64(5))))
+	clock.t = clock.t.Add(time.Second)
+	m, err = s.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(5)), m)
+}
+
+func TestCachedStorage_WriteThrough(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Now()}
+	mem := NewMemStorage()
+	s := newCachedStorage(mem, time.Minute, clock)
+
+	require.NoError(t, s.Insert(ctx, "gtest", NewMetricGauge(float64(1))))
+	require.NoError(t, s.Insert(ctx, "test", NewMetricCounter(int64(1))))
+	require.Equal(t, NewMetricGauge(float64(1)), storedMetric(t, mem, "gtest"))
+	require.Equal(t, NewMetricCounter(int64(1)), storedMetric(t, mem, "test"))
+
+	gauge, err := s.Get(ctx, "gtest")
+	require.NoError(t, err)
+	require.NoError(t, s.Update(ctx, "gtest", float64(2), gauge))
+	counter, err := s.Get(ctx, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.Update(ctx, "test", int64(2), counter))
+
+	//gauge is served from cache after write, counter is read from storage
+	before := s.CacheStats()
+	m, err := s.Get(ctx, "gtest")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricGauge(float64(2)), m)
+	m, err = s.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(3)), m)
+	require.Equal(t, CacheStats{Hits: before.Hits + 1, Misses: before.Misses + 1}, s.CacheStats())
+
+	require.NoError(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "gtest", MType: "gauge", ActualValue: float64(7)},
+	}))
+	m, err = s.Get(ctx, "gtest")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricGauge(float64(7)), m, "кэш должен быть сброшен после пакетного обновления")
+}
+
+func TestCachedStorage_SharedStorage(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Now()}
+	shared := NewMemStorage()
+	require.NoError(t, shared.Insert(ctx, "test", NewMetricCounter(int64(1))))
+	first := newCachedStorage(shared, time.Second, clock)
+	second := newCachedStorage(shared, time.Second, clock)
+
+	m, err := second.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(1)), m)
+
+	require.NoError(t, first.BatchUpdate(ctx, []Metrics{{ID: "test", ActualValue: int64(4)}}))
+
+	//change of other server is visible after ttl at the latest
+	m, err = second.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(1)), m)
+	clock.t = clock.t.Add(time.Second)
+	m, err = second.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(5)), m)
+}
+
+func TestCachedStorage_Size(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Now()}
+	mem := NewMemStorageWithValues(map[MetricName]Metric{
+		"a": NewMetricGauge(float64(1)),
+		"b": NewMetricGauge(float64(2)),
+	})
+	s := newCachedStorage(mem, time.Second, clock)
+	OptionWithCacheSize(1)(s)
+
+	_, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, s.entries, 1)
+
+	//stale entries make room for new ones
+	clock.t = clock.t.Add(time.Second)
+	_, err = s.Get(ctx, "b")
+	require.NoError(t, err)
+	require.Contains(t, s.entries, MetricName("b"))
+}
+
+func TestCachedStorage_EvictFirstExpiring(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Now()}
+	mem := NewMemStorageWithValues(map[MetricName]Metric{
+		"a": NewMetricGauge(float64(1)),
+		"b": NewMetricGauge(float64(2)),
+		"c": NewMetricGauge(float64(3)),
+	})
+	s := newCachedStorage(mem, time.Minute, clock)
+	OptionWithCacheSize(2)(s)
+
+	for _, key := range []MetricName{"a", "b"} {
+		_, err := s.Get(ctx, key)
+		require.NoError(t, err)
+		clock.t = clock.t.Add(time.Second)
+	}
+	//write of a refreshes its entry, so b expires first
+	require.NoError(t, s.Update(ctx, "a", float64(4), nil))
+	_, err := s.Get(ctx, "c")
+	require.NoError(t, err)
+	require.Len(t, s.entries, 2)
+	require.Contains(t, s.entries, MetricName("a"))
+	require.Contains(t, s.entries, MetricName("c"))
+	require.Equal(t, 2, s.order.Len())
+}
+
+// blockingStorage signals entered when write starts and applies it after release
+type blockingStorage struct {
+	*MemStorage
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStorage) Insert(ctx context.Context, key MetricName, m Metric) error {
+	b.entered <- struct{}{}
+	<-b.release
+	return b.MemStorage.Insert(ctx, key, m)
+}
+
+func (b *blockingStorage) BatchUpdate(ctx context.Context, metrics []Metrics) error {
+	b.entered <- struct{}{}
+	<-b.release
+	return b.MemStorage.BatchUpdate(ctx, metrics)
+}
+
+func TestCachedStorage_GetDuringWrite(t *testing.T) {
+	ctx := context.Background()
+	inner := &blockingStorage{
+		MemStorage: NewMemStorageWithValues(map[MetricName]Metric{
+			"counter": NewMetricCounter(int64(1)),
+		}),
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	s := newCachedStorage(inner, time.Minute, &fakeClock{t: time.Unix(0, 0)})
+
+	writes := []struct {
+		write         func() error
+		before, after int64
+	}{
+		{
+			write: func() error {
+				return s.Insert(ctx, "counter", NewMetricCounter(int64(5)))
+			},
+			before: 1,
+			after:  5,
+		},
+		{
+			write: func() error {
+				return s.BatchUpdate(ctx, []Metrics{{ID: "counter", MType: string(MetricTypeCounter), ActualValue: int64(1)}})
+			},
+			before: 5,
+			after:  6,
+		},
+	}
+	for _, w := range writes {
+		done := make(chan error)
+		go func() {
+			done <- w.write()
+		}()
+		<-inner.entered
+		//value read before write is applied
+		m, err := s.Get(ctx, "counter")
+		require.NoError(t, err)
+		require.Equal(t, NewMetricCounter(w.before), m)
+		inner.release <- struct{}{}
+		require.NoError(t, <-done)
+
+		m, err = s.Get(ctx, "counter")
+		require.NoError(t, err)
+		require.Equal(t, NewMetricCounter(w.after), m)
+	}
+}
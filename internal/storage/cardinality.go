@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrCardinalityExceeded = errors.New("max number of metrics is reached")
+
+// CardinalityStorage caps number of distinct metrics in wrapped storage. Names are counted by this instance,
+// so servers sharing storage may together exceed cap by metrics created elsewhere
+type CardinalityStorage struct {
+	Repositories          //wrapped storage
+	names        *nameSet //stored metric names, loaded on first write
+	mu           sync.Mutex
+	limit        int //max number of metrics
+}
+
+// NewCardinalityStorage is factory method
+func NewCardinalityStorage(next Repositories, limit int) *CardinalityStorage {
+	return &CardinalityStorage{
+		Repositories: next,
+		limit:        limit,
+	}
+}
+
+func (s *CardinalityStorage) Insert(ctx context.Context, name MetricName, m Metric) error {
+	done, err := s.reserve(ctx, []MetricName{name})
+	if err != nil {
+		return err
+	}
+	err = s.Repositories.Insert(ctx, name, m)
+	done(err == nil)
+	return err
+}
+
+func (s *CardinalityStorage) BatchUpdate(ctx context.Context, metrics []Metrics) error {
+	done, err := s.reserve(ctx, batchNames(metrics))
+	if err != nil {
+		return err
+	}
+	err = s.Repositories.BatchUpdate(ctx, metrics)
+	done(err == nil)
+	return err
+}
+
+func (s *CardinalityStorage) BatchSet(ctx context.Context, metrics []Metrics) error {
+	done, err := s.reserve(ctx, batchNames(metrics))
+	if err != nil {
+		return err
+	}
+	err = s.Repositories.BatchSet(ctx, metrics)
+	done(err == nil)
+	return err
+}
+
+// reserve reserves new metric names, whole batch is rejected if it doesn't fit cap.
+// Returned done must be called with result of write, names of failed write are released
+func (s *CardinalityStorage) reserve(ctx context.Context, names []MetricName) (func(applied bool), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.names == nil {
+		all, err := s.Repositories.GetAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.names = newNameSet(all)
+	}
+	reserved, ok := s.names.reserve(names, s.limit)
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrCardinalityExceeded, s.limit)
+	}
+	return func(applied bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.names.done(reserved, applied)
+	}, nil
+}
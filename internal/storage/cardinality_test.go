@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/esafronov/yp-metrics/internal/tenant"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardinalityStorage(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemStorageWithValues(map[MetricName]Metric{
+		"existing": NewMetricGauge(float64(1)),
+	})
+	s := NewCardinalityStorage(mem, 3)
+
+	//stored metrics are counted
+	require.NoError(t, s.Insert(ctx, "first", NewMetricCounter(int64(1))))
+	//batch is rejected as a whole
+	require.ErrorIs(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "second", ActualValue: int64(1)},
+		{ID: "third", ActualValue: int64(1)},
+	}), ErrCardinalityExceeded)
+	m, err := mem.Get(ctx, "second")
+	require.NoError(t, err)
+	require.Nil(t, m)
+	//updates of known metrics are not limited
+	require.NoError(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "existing", ActualValue: float64(2)},
+		{ID: "first", ActualValue: int64(1)},
+		{ID: "second", ActualValue: int64(1)},
+	}))
+	require.ErrorIs(t, s.Insert(ctx, "third", NewMetricCounter(int64(1))), ErrCardinalityExceeded)
+	require.Equal(t, NewMetricCounter(int64(2)), storedMetric(t, mem, "first"))
+}
+
+func TestCardinalityStorage_FailedWrite(t *testing.T) {
+	ctx := context.Background()
+	inner := &failingStorage{MemStorage: NewMemStorage(), err: errors.New("connection reset")}
+	s := NewCardinalityStorage(inner, 2)
+
+	//names of failed writes are not counted
+	require.ErrorIs(t, s.Insert(ctx, "first", NewMetricCounter(int64(1))), inner.err)
+	require.ErrorIs(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "second", ActualValue: int64(1)},
+		{ID: "third", ActualValue: int64(1)},
+	}), inner.err)
+	require.ErrorIs(t, s.BatchSet(ctx, []Metrics{
+		{ID: "fourth", ActualValue: int64(1)},
+	}), inner.err)
+
+	inner.err = nil
+	require.NoError(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "fifth", ActualValue: int64(1)},
+		{ID: "sixth", ActualValue: int64(1)},
+	}))
+	require.ErrorIs(t, s.Insert(ctx, "first", NewMetricCounter(int64(1))), ErrCardinalityExceeded)
+}
+
+func TestCardinalityStorage_RejectedInTenantStorage(t *testing.T) {
+	ctx := tenant.NewContext(context.Background(), "team-a")
+	c := NewCardinalityStorage(NewMemStorageWithValues(map[MetricName]Metric{
+		"existing": NewMetricCounter(int64(1)),
+	}), 1)
+	s := NewTenantStorage(c, OptionWithTenantQuota(1))
+
+	//batch rejected by cap isn't counted in tenant quota
+	require.ErrorIs(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "first", ActualValue: int64(1)},
+	}), ErrCardinalityExceeded)
+	require.Empty(t, s.names["team-a"].stored)
+	require.Empty(t, s.names["team-a"].pending)
+}
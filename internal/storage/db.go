@@ -2,63 +2,82 @@ package storage
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/esafronov/yp-metrics/internal/logger"
+	"github.com/esafronov/yp-metrics/internal/retry"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 const tableName string = "metrics"
 
+// defaultRetryTimeout max time of operation with retries after which no pause is started, request isn't stalled by whole schedule
+const defaultRetryTimeout time.Duration = 5 * time.Second
+
+// PgxPool is subset of pgxpool.Pool methods used by DBStorage, it allows to substitute pool in tests
+type PgxPool interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Ping(ctx context.Context) error
+	Close()
+}
+
 type DBStorage struct {
-	db *sql.DB
+	db            PgxPool         //primary, all writes go here
+	replicas      []*replica      //read-only replicas for Get and GetAll
+	retrySchedule []time.Duration //pauses between tries of operation failed with transient error
+	retryTimeout  time.Duration   //pauses are not started after this time since operation start, 0 = not limited
+	retries       retryCounters
+	nextReplica   atomic.Uint64 //round robin position in replicas
+	maxReplicaLag time.Duration //replica with greater lag is not used for reading
 }
 
-func NewDBStorage(ctx context.Context, db *sql.DB) (*DBStorage, error) {
+// NewDBStorage is factory method, storage takes ownership of pools and closes them on Close
+func NewDBStorage(ctx context.Context, db PgxPool, opts ...func(s *DBStorage)) (*DBStorage, error) {
 	storage := &DBStorage{
-		db,
+		db:            db,
+		retrySchedule: retry.DefaultSchedule(),
+		retryTimeout:  defaultRetryTimeout,
+	}
+	for _, f := range opts {
+		f(storage)
 	}
-	if err := storage.createTable(ctx); err != nil {
+	//bring schema up to date before serving requests
+	if _, err := NewMigrator(db).Up(ctx); err != nil {
 		return nil, err
 	}
 	return storage, nil
 }
 
 func (s *DBStorage) Get(ctx context.Context, key MetricName) (Metric, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT value_gauge, value_counter FROM "+tableName+
-		" WHERE metric_name = $1 LIMIT 1", string(key))
+	var gaugeValue pgtype.Float8
+	var counterValue pgtype.Int8
+	err := s.read(ctx, "get", func(db PgxPool) error {
+		return db.QueryRow(ctx, "SELECT value_gauge, value_counter FROM "+tableName+
+			" WHERE metric_name = $1 LIMIT 1", string(key)).Scan(&gaugeValue, &counterValue)
+	})
 	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		err = rows.Close()
-		if err != nil {
-			logger.Log.Info(err.Error())
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
 		}
-	}()
-	if !rows.Next() {
-		return nil, nil
-	}
-	var gaugeValue sql.NullFloat64
-	var counterValue sql.NullInt64
-	err = rows.Scan(&gaugeValue, &counterValue)
-	if err != nil {
 		return nil, err
 	}
 	//test gauge value is not null
 	if gaugeValue.Valid {
-		v := gaugeValue.Float64
-		return NewMetricGauge(v), nil
+		return NewMetricGauge(gaugeValue.Float64), nil
 	}
 	//test counter value is not null
 	if counterValue.Valid {
-		v := counterValue.Int64
-		return NewMetricCounter(v), nil
-	}
-	// проверяем на ошибки
-	err = rows.Err()
-	if err != nil {
-		return nil, err
+		return NewMetricCounter(counterValue.Int64), nil
 	}
 	return nil, fmt.Errorf("metric value is null")
 }
@@ -67,34 +86,42 @@ func (s *DBStorage) Insert(ctx context.Context, key MetricName, m Metric) error
 	if m == nil {
 		return fmt.Errorf("metric is nil")
 	}
+	var query string
+	var args []any
 	switch m.(type) {
 	case *MetricCounter:
 		val := m.GetValue().(int64)
-		_, err := s.db.ExecContext(ctx, "INSERT INTO "+tableName+"(metric_name, metric_type, value_counter) VALUES ($1,$2,$3) ON CONFLICT (metric_name) DO UPDATE SET value_counter=EXCLUDED.value_counter+$3", string(key), MetricTypeCounter, val)
-		if err != nil {
-			return err
-		}
+		query = "INSERT INTO " + tableName + "(metric_name, metric_type, value_counter) VALUES ($1,$2,$3) ON CONFLICT (metric_name) DO UPDATE SET value_counter=" + tableName + ".value_counter+EXCLUDED.value_counter"
+		args = []any{string(key), string(MetricTypeCounter), val}
 	case *MetricGauge:
 		val := m.GetValue().(float64)
-		_, err := s.db.ExecContext(ctx, "INSERT INTO "+tableName+"(metric_name, metric_type, value_gauge) VALUES ($1,$2,$3) ON CONFLICT (metric_name) DO UPDATE SET value_gauge=$3", string(key), MetricTypeGauge, val)
-		if err != nil {
-			return err
-		}
+		query = "INSERT INTO " + tableName + "(metric_name, metric_type, value_gauge) VALUES ($1,$2,$3) ON CONFLICT (metric_name) DO UPDATE SET value_gauge=$3"
+		args = []any{string(key), string(MetricTypeGauge), val}
 	default:
 		return fmt.Errorf("metric type is unknown")
 	}
-	return nil
+	//increment of counter is not repeated if it might be applied
+	_, idempotent := m.(*MetricGauge)
+	return s.withRetry(ctx, "insert", idempotent, func() error {
+		_, err := s.db.Exec(ctx, query, args...)
+		return err
+	})
 }
 
 func (s *DBStorage) Update(ctx context.Context, key MetricName, v interface{}, metric Metric) error {
-	switch val := v.(type) {
+	var query string
+	switch v.(type) {
 	case int64:
-		_, err := s.db.ExecContext(ctx, "UPDATE "+tableName+" SET value_counter=value_counter+$1 WHERE metric_name=$2", val, string(key))
-		if err != nil {
-			return err
-		}
+		query = "UPDATE " + tableName + " SET value_counter=value_counter+$1 WHERE metric_name=$2"
 	case float64:
-		_, err := s.db.ExecContext(ctx, "UPDATE "+tableName+" SET value_gauge=$1 WHERE metric_name=$2", val, string(key))
+		query = "UPDATE " + tableName + " SET value_gauge=$1 WHERE metric_name=$2"
+	}
+	if query != "" {
+		_, idempotent := v.(float64)
+		err := s.withRetry(ctx, "update", idempotent, func() error {
+			_, err := s.db.Exec(ctx, query, v, string(key))
+			return err
+		})
 		if err != nil {
 			return err
 		}
@@ -103,84 +130,169 @@ func (s *DBStorage) Update(ctx context.Context, key MetricName, v interface{}, m
 	return nil
 }
 
+// batchChunkSize max rows in one multi-row upsert statement, keeps number of params far below postgres limit
+const batchChunkSize int = 1000
+
+// BatchUpdate upserts metrics with multi-row statements in one transaction.
+// Duplicates in batch are collapsed before sending: counter deltas are summed, last gauge value wins
 func (s *DBStorage) BatchUpdate(ctx context.Context, metrics []Metrics) error {
-	tx, err := s.db.BeginTx(ctx, nil)
+	rows, err := collapseBatch(metrics)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		err = tx.Rollback()
-		if err != nil {
-			logger.Log.Info(err.Error())
-		}
-	}()
-	stmCount, err := tx.PrepareContext(ctx, "SELECT COUNT(*) FROM "+tableName+" WHERE metric_name=$1")
-	if err != nil {
-		return err
+	if len(rows) == 0 {
+		return nil
 	}
-	stmUpdGauge, err := tx.PrepareContext(ctx, "UPDATE "+tableName+" SET value_gauge=$1 WHERE metric_name=$2")
+	//whole transaction is repeated if it is rolled back, transaction with counters isn't repeated if commit might be applied
+	idempotent := !slices.ContainsFunc(rows, func(m Metrics) bool {
+		_, ok := m.ActualValue.(int64)
+		return ok
+	})
+	return s.withRetry(ctx, "batch update", idempotent, func() error {
+		return s.upsertRows(ctx, rows, false)
+	})
+}
+
+// BatchSet upserts metrics like BatchUpdate, stored counters are replaced. Last value of duplicates wins
+func (s *DBStorage) BatchSet(ctx context.Context, metrics []Metrics) error {
+	rows, err := collapseSet(metrics)
 	if err != nil {
 		return err
 	}
-	stmUpdCounter, err := tx.PrepareContext(ctx, "UPDATE "+tableName+" SET value_counter=value_counter+$1 WHERE metric_name=$2")
-	if err != nil {
-		return err
+	if len(rows) == 0 {
+		return nil
 	}
-	stmInsGauge, err := tx.PrepareContext(ctx, "INSERT INTO "+tableName+"(metric_name, metric_type, value_gauge) VALUES ($1, $2, $3) ON CONFLICT (metric_name) DO UPDATE SET value_gauge=$3")
+	return s.withRetry(ctx, "batch set", true, func() error {
+		return s.upsertRows(ctx, rows, true)
+	})
+}
+
+// upsertRows writes collapsed rows in one transaction, counters are replaced if set is true
+func (s *DBStorage) upsertRows(ctx context.Context, rows []Metrics, set bool) error {
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	stmInsCounter, err := tx.PrepareContext(ctx, "INSERT INTO "+tableName+"(metric_name, metric_type, value_counter) VALUES ($1, $2, $3) ON CONFLICT (metric_name) DO UPDATE SET value_counter=EXCLUDED.value_counter+$3")
-	if err != nil {
+	defer func() {
+		err = tx.Rollback(context.Background())
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.Log.Info(err.Error())
+		}
+	}()
+	for start := 0; start < len(rows); start += batchChunkSize {
+		end := min(start+batchChunkSize, len(rows))
+		query, args := upsertQuery(rows[start:end], set)
+		if _, err = tx.Exec(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
 		return err
 	}
-	var count int
+	return nil
+}
+
+// collapseBatch merges metrics with the same name and sorts them by name,
+// so concurrent batches lock rows in the same order
+func collapseBatch(metrics []Metrics) ([]Metrics, error) {
+	index := make(map[string]int, len(metrics))
+	rows := make([]Metrics, 0, len(metrics))
 	for _, m := range metrics {
-		value := m.ActualValue
-		row := stmCount.QueryRowContext(ctx, m.ID)
-		if err = row.Scan(&count); err != nil {
-			return err
-		}
-		switch val := value.(type) {
+		switch val := m.ActualValue.(type) {
 		case int64:
-			if count > 0 {
-				_, err = stmUpdCounter.ExecContext(ctx, val, m.ID)
-			} else {
-				_, err = stmInsCounter.ExecContext(ctx, m.ID, m.MType, val)
+			if i, ok := index[m.ID]; ok {
+				if prev, ok := rows[i].ActualValue.(int64); ok {
+					rows[i].ActualValue = prev + val
+					continue
+				}
+				rows[i].ActualValue = val
+				continue
 			}
 		case float64:
-			if count > 0 {
-				_, err = stmUpdGauge.ExecContext(ctx, val, m.ID)
-			} else {
-				_, err = stmInsGauge.ExecContext(ctx, m.ID, m.MType, val)
+			if i, ok := index[m.ID]; ok {
+				rows[i].ActualValue = val
+				continue
 			}
 		default:
-			err = fmt.Errorf("metric type unknown in batch update")
+			return nil, fmt.Errorf("metric type unknown in batch update")
 		}
-		if err != nil {
-			return err
+		index[m.ID] = len(rows)
+		rows = append(rows, m)
+	}
+	slices.SortFunc(rows, func(a, b Metrics) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return rows, nil
+}
+
+// collapseSet keeps last metric with the same name and sorts them by name like collapseBatch
+func collapseSet(metrics []Metrics) ([]Metrics, error) {
+	index := make(map[string]int, len(metrics))
+	rows := make([]Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		switch m.ActualValue.(type) {
+		case int64, float64:
+		default:
+			return nil, fmt.Errorf("metric type unknown in batch set")
 		}
+		if i, ok := index[m.ID]; ok {
+			rows[i] = m
+			continue
+		}
+		index[m.ID] = len(rows)
+		rows = append(rows, m)
 	}
-	if err = tx.Commit(); err != nil {
-		return err
+	slices.SortFunc(rows, func(a, b Metrics) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return rows, nil
+}
+
+// upsertQuery builds multi-row upsert, counters are added to stored value unless set is true, gauges are replaced
+func upsertQuery(rows []Metrics, set bool) (string, []any) {
+	var b strings.Builder
+	args := make([]any, 0, len(rows)*4)
+	b.WriteString("INSERT INTO " + tableName + "(metric_name, metric_type, value_gauge, value_counter) VALUES ")
+	for i, m := range rows {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		n := len(args)
+		fmt.Fprintf(&b, "($%d,$%d,$%d,$%d)", n+1, n+2, n+3, n+4)
+		switch val := m.ActualValue.(type) {
+		case int64:
+			args = append(args, m.ID, string(MetricTypeCounter), nil, val)
+		case float64:
+			args = append(args, m.ID, string(MetricTypeGauge), val, nil)
+		}
 	}
-	return nil
+	b.WriteString(" ON CONFLICT (metric_name) DO UPDATE SET metric_type=EXCLUDED.metric_type," +
+		" value_gauge=EXCLUDED.value_gauge,")
+	if set {
+		b.WriteString(" value_counter=EXCLUDED.value_counter")
+	} else {
+		b.WriteString(" value_counter=COALESCE(" + tableName + ".value_counter, 0)+EXCLUDED.value_counter")
+	}
+	return b.String(), args
+}
+
+func (s *DBStorage) GetAll(ctx context.Context) (metrics map[MetricName]Metric, err error) {
+	err = s.read(ctx, "get all", func(db PgxPool) error {
+		metrics, err = s.getAll(ctx, db)
+		return err
+	})
+	return
 }
 
-func (s *DBStorage) GetAll(ctx context.Context) (map[MetricName]Metric, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT metric_name, value_gauge, value_counter FROM "+tableName)
+func (s *DBStorage) getAll(ctx context.Context, db PgxPool) (map[MetricName]Metric, error) {
+	rows, err := db.Query(ctx, "SELECT metric_name, value_gauge, value_counter FROM "+tableName)
 	if err != nil {
 		return nil, err
 	}
 	// обязательно закрываем перед возвратом функции
-	defer func() {
-		err = rows.Close()
-		if err != nil {
-			logger.Log.Info(err.Error())
-		}
-	}()
-	var gaugeValue sql.NullFloat64
-	var counterValue sql.NullInt64
+	defer rows.Close()
+	var gaugeValue pgtype.Float8
+	var counterValue pgtype.Int8
 	var metricName string
 	metrics := map[MetricName]Metric{}
 	for rows.Next() {
@@ -190,13 +302,11 @@ func (s *DBStorage) GetAll(ctx context.Context) (map[MetricName]Metric, error) {
 		}
 		//test gauge value is not null
 		if gaugeValue.Valid {
-			v := gaugeValue.Float64
-			metrics[MetricName(metricName)] = NewMetricGauge(v)
+			metrics[MetricName(metricName)] = NewMetricGauge(gaugeValue.Float64)
 		}
 		//test counter value is not null
 		if counterValue.Valid {
-			v := counterValue.Int64
-			metrics[MetricName(metricName)] = NewMetricCounter(v)
+			metrics[MetricName(metricName)] = NewMetricCounter(counterValue.Int64)
 		}
 	}
 	// проверяем на ошибки
@@ -207,44 +317,13 @@ func (s *DBStorage) GetAll(ctx context.Context) (map[MetricName]Metric, error) {
 	return metrics, nil
 }
 
-func (s *DBStorage) createTable(ctx context.Context) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	// roll back if commit will fail
-	defer func() {
-		err = tx.Rollback()
-		if err != nil {
-			logger.Log.Info(err.Error())
-		}
-	}()
-	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+
-		tableName+
-		`(
-			id SERIAL,
-			metric_name VARCHAR(30) NOT NULL,
-			metric_type VARCHAR(7) NOT NULL,
-			value_gauge DOUBLE PRECISION DEFAULT NULL,
-			value_counter BIGINT DEFAULT NULL
-		)`)
-	if err != nil {
-		return err
-	}
-	_, err = tx.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS metric_name ON `+tableName+` (metric_name)`)
-	if err != nil {
-		return err
-	}
-	if err = tx.Commit(); err != nil {
-		return err
-	}
-	return nil
+// Ping checks database is reachable
+func (s *DBStorage) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
 }
 
 func (s *DBStorage) Close(ctx context.Context) error {
-	err := s.db.Close()
-	if err != nil {
-		return err
-	}
+	s.closeReplicas()
+	s.db.Close()
 	return nil
 }
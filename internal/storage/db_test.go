@@ -2,19 +2,22 @@ package storage
 
 import (
 	"context"
+	"os"
+	"strconv"
 	"testing"
 
-	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pashagolub/pgxmock/v4"
 	"github.com/stretchr/testify/require"
 )
 
 func TestDBStorage_Get(t *testing.T) {
-	db, mock, err := sqlmock.New()
+	mock, err := pgxmock.NewPool()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	s := &DBStorage{
-		db: db,
+		db: mock,
 	}
 	require.NoError(t, err)
 
@@ -60,7 +63,7 @@ func TestDBStorage_Get(t *testing.T) {
 	ctx := context.Background()
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			rows := sqlmock.NewRows([]string{"value_gauge", "value_counter"})
+			rows := pgxmock.NewRows([]string{"value_gauge", "value_counter"})
 
 			if tt.arg.t == MetricTypeCounter {
 				rows.AddRow(nil, tt.arg.v.(int64))
@@ -71,7 +74,7 @@ func TestDBStorage_Get(t *testing.T) {
 			}
 
 			mock.ExpectQuery("^SELECT value_gauge, value_counter").
-				WithArgs(tt.arg.key).
+				WithArgs(string(tt.arg.key)).
 				WillReturnRows(rows)
 
 			m, err := s.Get(ctx, tt.arg.key)
@@ -89,13 +92,13 @@ func TestDBStorage_Get(t *testing.T) {
 
 func TestDBStorage_Insert(t *testing.T) {
 
-	db, mock, err := sqlmock.New()
+	mock, err := pgxmock.NewPool()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	ctx := context.Background()
 	s := &DBStorage{
-		db: db,
+		db: mock,
 	}
 	require.NoError(t, err)
 
@@ -160,8 +163,8 @@ func TestDBStorage_Insert(t *testing.T) {
 
 			v := tt.arg.v.GetValue()
 			mock.ExpectExec("^INSERT INTO").
-				WithArgs(tt.arg.key, tt.arg.t, v).
-				WillReturnResult(sqlmock.NewResult(tt.want.lastID, tt.want.effected))
+				WithArgs(string(tt.arg.key), tt.arg.t, v).
+				WillReturnResult(pgxmock.NewResult("INSERT", tt.want.effected))
 
 			if err := s.Insert(ctx, tt.arg.key, tt.arg.v); err != nil {
 				t.Errorf("error was not expected : %s", err)
@@ -176,13 +179,13 @@ func TestDBStorage_Insert(t *testing.T) {
 }
 
 func TestDBStorage_Update(t *testing.T) {
-	db, mock, err := sqlmock.New()
+	mock, err := pgxmock.NewPool()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	ctx := context.Background()
 	s := &DBStorage{
-		db: db,
+		db: mock,
 	}
 	require.NoError(t, err)
 
@@ -228,8 +231,8 @@ func TestDBStorage_Update(t *testing.T) {
 			v := tt.arg.v.GetValue()
 
 			mock.ExpectExec("^UPDATE").
-				WithArgs(v, tt.arg.key).
-				WillReturnResult(sqlmock.NewResult(tt.want.lastID, tt.want.effected))
+				WithArgs(v, string(tt.arg.key)).
+				WillReturnResult(pgxmock.NewResult("UPDATE", tt.want.effected))
 
 			if err := s.Update(ctx, tt.arg.key, v, tt.arg.v); err != nil {
 				t.Errorf("error was not expected : %s", err)
@@ -243,14 +246,14 @@ func TestDBStorage_Update(t *testing.T) {
 }
 
 func TestDBStorage_BatchUpdate(t *testing.T) {
-	db, mock, err := sqlmock.New()
+	mock, err := pgxmock.NewPool()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	ctx := context.Background()
 	require.NoError(t, err)
 	s := &DBStorage{
-		db: db,
+		db: mock,
 	}
 
 	metrics := []Metrics{{
@@ -271,43 +274,11 @@ func TestDBStorage_BatchUpdate(t *testing.T) {
 		ActualValue: float64(0.2),
 	}}
 
+	//duplicates are collapsed, rows are sorted by name
 	mock.ExpectBegin()
-	mock.ExpectPrepare("^SELECT COUNT")
-	mock.ExpectPrepare("^UPDATE")
-	mock.ExpectPrepare("^UPDATE")
-	mock.ExpectPrepare("^INSERT")
-	mock.ExpectPrepare("^INSERT")
-	mock.ExpectQuery("^SELECT COUNT").
-		WithArgs("test").
-		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
-
-	mock.ExpectExec("^INSERT INTO").
-		WithArgs("test", "counter", 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-
-	mock.ExpectQuery("^SELECT COUNT").
-		WithArgs("test").
-		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
-
-	mock.ExpectExec("^UPDATE").
-		WithArgs(1, "test").
-		WillReturnResult(sqlmock.NewResult(0, 1))
-
-	mock.ExpectQuery("^SELECT COUNT").
-		WithArgs("gtest").
-		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
-
-	mock.ExpectExec("^INSERT INTO").
-		WithArgs("gtest", "gauge", 0.1).
-		WillReturnResult(sqlmock.NewResult(2, 1))
-
-	mock.ExpectQuery("^SELECT COUNT").
-		WithArgs("gtest").
-		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
-
-	mock.ExpectExec("^UPDATE").
-		WithArgs(0.2, "gtest").
-		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^INSERT INTO "+tableName).
+		WithArgs("gtest", "gauge", 0.2, nil, "test", "counter", nil, int64(2)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
 	mock.ExpectCommit()
 
 	if err = s.BatchUpdate(ctx, metrics); err != nil {
@@ -320,19 +291,179 @@ func TestDBStorage_BatchUpdate(t *testing.T) {
 
 }
 
+func TestDBStorage_BatchSet(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := &DBStorage{
+		db: mock,
+	}
+	metrics := []Metrics{
+		{ID: "test", ActualValue: int64(1)},
+		{ID: "test", ActualValue: int64(5)},
+		{ID: "gtest", ActualValue: float64(0.1)},
+	}
+	//last value of duplicates wins, stored counter is replaced
+	mock.ExpectBegin()
+	mock.ExpectExec(`^INSERT INTO .* value_counter=EXCLUDED\.value_counter$`).
+		WithArgs("gtest", "gauge", 0.1, nil, "test", "counter", nil, int64(5)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	mock.ExpectCommit()
+
+	require.NoError(t, s.BatchSet(context.Background(), metrics))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_BatchUpdateChunks(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := &DBStorage{
+		db: mock,
+	}
+	metrics := make([]Metrics, 0, batchChunkSize+1)
+	for i := 0; i <= batchChunkSize; i++ {
+		metrics = append(metrics, Metrics{
+			ID:          "test" + strconv.Itoa(i),
+			MType:       "counter",
+			ActualValue: int64(1),
+		})
+	}
+	anyArgs := func(n int) []any {
+		args := make([]any, n)
+		for i := range args {
+			args[i] = pgxmock.AnyArg()
+		}
+		return args
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec("^INSERT INTO " + tableName).
+		WithArgs(anyArgs(batchChunkSize * 4)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", int64(batchChunkSize)))
+	mock.ExpectExec("^INSERT INTO " + tableName).
+		WithArgs(anyArgs(4)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+
+	if err = s.BatchUpdate(context.Background(), metrics); err != nil {
+		t.Errorf("error was not expected : %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// benchMetrics returns n metrics with distinct names, counters and gauges alternate
+func benchMetrics(n int) []Metrics {
+	metrics := make([]Metrics, 0, n)
+	for i := 0; i < n; i++ {
+		m := Metrics{ID: "bench" + strconv.Itoa(i)}
+		if i%2 == 0 {
+			m.ActualValue = int64(i)
+		} else {
+			m.ActualValue = float64(i) / 10
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// expectBatchUpdate sets expectations of batch update of n distinct metrics, returns number of round trips to database
+func expectBatchUpdate(mock pgxmock.PgxPoolIface, n int) int {
+	mock.ExpectBegin()
+	roundTrips := 2
+	for start := 0; start < n; start += batchChunkSize {
+		rows := min(batchChunkSize, n-start)
+		args := make([]any, rows*4)
+		for i := range args {
+			args[i] = pgxmock.AnyArg()
+		}
+		mock.ExpectExec("^INSERT INTO " + tableName).
+			WithArgs(args...).
+			WillReturnResult(pgxmock.NewResult("INSERT", int64(rows)))
+		roundTrips++
+	}
+	mock.ExpectCommit()
+	return roundTrips
+}
+
+// batch of 10k metrics is written by one statement per chunk instead of 2 statements per metric
+func TestDBStorage_BatchUpdateRoundTrips(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	s := &DBStorage{db: mock}
+	roundTrips := expectBatchUpdate(mock, 10000)
+	require.NoError(t, s.BatchUpdate(context.Background(), benchMetrics(10000)))
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, 12, roundTrips)
+}
+
+// benchmark for batch update of 10k metrics against mock of pool, measures building of statements and
+// reports number of round trips to database per batch
+func BenchmarkDBStorage_BatchUpdateMock(b *testing.B) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(b, err)
+	s := &DBStorage{db: mock}
+	const batchSize = 10000
+	metrics := benchMetrics(batchSize)
+	var roundTrips int
+	for i := 0; i < b.N; i++ {
+		roundTrips = expectBatchUpdate(mock, batchSize)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.BatchUpdate(context.Background(), metrics); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	require.NoError(b, mock.ExpectationsWereMet())
+	b.ReportMetric(float64(roundTrips), "roundtrips/op")
+	b.ReportMetric(float64(b.N*batchSize)/b.Elapsed().Seconds(), "metrics/s")
+}
+
+// benchmark for batch update of 10k metrics, runs against real database if TEST_DATABASE_DSN is set
+func BenchmarkDBStorage_BatchUpdate(b *testing.B) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_DSN is not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	require.NoError(b, err)
+	s, err := NewDBStorage(ctx, pool)
+	require.NoError(b, err)
+	defer func() {
+		if err := s.Close(ctx); err != nil {
+			b.Error(err)
+		}
+	}()
+	const batchSize = 10000
+	metrics := benchMetrics(batchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.BatchUpdate(ctx, metrics); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*batchSize)/b.Elapsed().Seconds(), "metrics/s")
+}
+
 func TestDBStorage_GetAll(t *testing.T) {
-	db, mock, err := sqlmock.New()
+	mock, err := pgxmock.NewPool()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	s := &DBStorage{
-		db: db,
+		db: mock,
 	}
 	require.NoError(t, err)
 	mock.ExpectQuery("SELECT metric_name, value_gauge, value_counter").
 		WillReturnRows(mock.NewRows([]string{"metric_name", "value_gauge", "value_counter"}).
-			AddRow("test1", nil, 1).
-			AddRow("test2", nil, 2).
+			AddRow("test1", nil, int64(1)).
+			AddRow("test2", nil, int64(2)).
 			AddRow("test3", 0.1, nil))
 
 	metrics, err := s.GetAll(context.Background())
@@ -358,32 +489,13 @@ func TestDBStorage_GetAll(t *testing.T) {
 
 }
 
-func TestDBStorage_createTable(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	mock.ExpectBegin()
-	mock.ExpectExec("^CREATE TABLE IF NOT EXISTS").WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectExec("^CREATE UNIQUE INDEX IF NOT EXISTS").WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectCommit()
-	s := &DBStorage{
-		db: db,
-	}
-	require.NoError(t, err)
-	if err := s.createTable(context.Background()); err != nil {
-		t.Fatalf("an error '%s' was not expected when creating table", err)
-	}
-
-}
-
 func TestDBStorage_Close(t *testing.T) {
-	db, mock, err := sqlmock.New()
+	mock, err := pgxmock.NewPool()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	s := &DBStorage{
-		db: db,
+		db: mock,
 	}
 	require.NoError(t, err)
 	mock.ExpectClose()
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/esafronov/yp-metrics/internal/logger"
+	"github.com/esafronov/yp-metrics/internal/retry"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+const (
+	pgClassConnectionException string = "08"    //connection_exception class
+	pgSerializationFailure     string = "40001" //serialization_failure
+	pgDeadlockDetected         string = "40P01" //deadlock_detected
+)
+
+// isTransientDBError reports whether operation failed with error that may pass on repeat:
+// connection exceptions, serialization failures, deadlocks and connect errors.
+// Errors of statements which might have reached server are not transient unless server reported them
+func isTransientDBError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, pgClassConnectionException) ||
+			pgErr.Code == pgSerializationFailure ||
+			pgErr.Code == pgDeadlockDetected
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	//error happened before request was sent to server
+	return pgconn.SafeToRetry(err)
+}
+
+// isRepeatableDBError reports whether not idempotent operation, e.g. increment of counter, may be repeated after error:
+// statement wasn't applied because it wasn't sent to server or server rolled it back.
+// Connection exceptions are not repeatable, statement or commit might be applied before connection was lost
+func isRepeatableDBError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	return pgconn.SafeToRetry(err)
+}
+
+// RetryStats counters of DBStorage retries
+type RetryStats struct {
+	Retries   int64 //number of repeated calls
+	Recovered int64 //operations succeeded after retries
+	Exhausted int64 //operations failed with transient error after all tries
+}
+
+// retryCounters atomic counters behind RetryStats
+type retryCounters struct {
+	retries   atomic.Int64
+	recovered atomic.Int64
+	exhausted atomic.Int64
+}
+
+// RetryStats returns snapshot of retry counters
+func (s *DBStorage) RetryStats() RetryStats {
+	return RetryStats{
+		Retries:   s.retries.retries.Load(),
+		Recovered: s.retries.recovered.Load(),
+		Exhausted: s.retries.exhausted.Load(),
+	}
+}
+
+// withRetry runs database operation op, repeating it on transient errors by storage retry schedule.
+// Operation which is not idempotent is repeated only if it wasn't applied.
+// Pauses are not started after retry timeout since operation start
+func (s *DBStorage) withRetry(ctx context.Context, op string, idempotent bool, f func() error) error {
+	transient := isTransientDBError
+	if !idempotent {
+		transient = isRepeatableDBError
+	}
+	retryCtx := ctx
+	if s.retryTimeout > 0 {
+		var cancel context.CancelFunc
+		retryCtx, cancel = context.WithTimeout(ctx, s.retryTimeout)
+		defer cancel()
+	}
+	retries, err := retry.Do(retryCtx, s.retrySchedule, func(err error) bool {
+		if !transient(err) {
+			return false
+		}
+		logger.Log.Info("transient db error, retrying", zap.String("op", op), zap.Error(err))
+		return true
+	}, f)
+	s.retries.retries.Add(int64(retries))
+	switch {
+	case err == nil && retries > 0:
+		s.retries.recovered.Add(1)
+	case transient(err):
+		s.retries.exhausted.Add(1)
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_isTransientDBError(t *testing.T) {
+	tests := []struct {
+		err  error
+		name string
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: true},
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "deadlock", err: fmt.Errorf("wrapped %w", &pgconn.PgError{Code: "40P01"}), want: true},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}, want: false},
+		{name: "connect error", err: &pgconn.ConnectError{}, want: true},
+		{name: "context canceled", err: context.Canceled, want: false},
+		{name: "other error", err: errors.New("other"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isTransientDBError(tt.err))
+		})
+	}
+}
+
+func TestDBStorage_Retry(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := &DBStorage{
+		db:            mock,
+		retrySchedule: []time.Duration{time.Millisecond, time.Millisecond, 0},
+	}
+	mock.ExpectExec("^UPDATE").
+		WithArgs(int64(1), "test").
+		WillReturnError(&pgconn.PgError{Code: "40001"})
+	mock.ExpectExec("^UPDATE").
+		WithArgs(int64(1), "test").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	err = s.Update(context.Background(), "test", int64(1), NewMetricCounter(int64(1)))
+	require.NoError(t, err)
+	require.Equal(t, RetryStats{Retries: 1, Recovered: 1}, s.RetryStats())
+
+	//not transient error is returned at once
+	mock.ExpectExec("^UPDATE").
+		WithArgs(int64(1), "test").
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+	err = s.Update(context.Background(), "test", int64(1), NewMetricCounter(int64(1)))
+	require.Error(t, err)
+	require.Equal(t, RetryStats{Retries: 1, Recovered: 1}, s.RetryStats())
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func Test_isRepeatableDBError(t *testing.T) {
+	tests := []struct {
+		err  error
+		name string
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: false},
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "deadlock", err: fmt.Errorf("wrapped %w", &pgconn.PgError{Code: "40P01"}), want: true},
+		{name: "connect error", err: &pgconn.ConnectError{}, want: true},
+		{name: "other error", err: errors.New("other"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isRepeatableDBError(tt.err))
+		})
+	}
+}
+
+func TestDBStorage_RetryCounterNotRepeated(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	s := &DBStorage{
+		db:            mock,
+		retrySchedule: []time.Duration{time.Millisecond, time.Millisecond, 0},
+	}
+	connReset := &pgconn.PgError{Code: "08006"}
+
+	//increment might be applied before connection was lost, it isn't sent again
+	mock.ExpectExec("^INSERT").
+		WithArgs("test", string(MetricTypeCounter), int64(1)).
+		WillReturnError(connReset)
+	require.ErrorIs(t, s.Insert(context.Background(), "test", NewMetricCounter(int64(1))), connReset)
+
+	counter := NewMetricCounter(int64(1))
+	mock.ExpectExec("^UPDATE").
+		WithArgs(int64(1), "test").
+		WillReturnError(connReset)
+	require.ErrorIs(t, s.Update(context.Background(), "test", int64(1), counter), connReset)
+	require.Equal(t, int64(1), counter.GetValue())
+
+	//connection is lost after commit of transaction with counters
+	mock.ExpectBegin()
+	mock.ExpectExec("^INSERT").
+		WithArgs("test", string(MetricTypeCounter), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit().WillReturnError(connReset)
+	err = s.BatchUpdate(context.Background(), []Metrics{{ID: "test", MType: string(MetricTypeCounter), ActualValue: int64(1)}})
+	require.ErrorIs(t, err, connReset)
+	require.Equal(t, RetryStats{}, s.RetryStats())
+
+	//gauge is set again
+	mock.ExpectExec("^INSERT").
+		WithArgs("test", string(MetricTypeGauge), 0.5).
+		WillReturnError(connReset)
+	mock.ExpectExec("^INSERT").
+		WithArgs("test", string(MetricTypeGauge), 0.5).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	require.NoError(t, s.Insert(context.Background(), "test", NewMetricGauge(0.5)))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_RetryTimeout(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	s := &DBStorage{
+		db:            mock,
+		retrySchedule: []time.Duration{10 * time.Millisecond, time.Minute, 0},
+		retryTimeout:  time.Second,
+	}
+	for i := 0; i < 2; i++ {
+		mock.ExpectExec("^UPDATE").
+			WithArgs(0.5, "test").
+			WillReturnError(&pgconn.PgError{Code: "40001"})
+	}
+	//pause exceeding retry timeout is not started
+	start := time.Now()
+	err = s.Update(context.Background(), "test", 0.5, NewMetricGauge(0.5))
+	require.Error(t, err)
+	require.Less(t, time.Since(start), time.Second)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// defaultImportBatchSize number of metrics imported by one BatchSet
+const defaultImportBatchSize int = 1000
+
+var ErrDumpInvalid = errors.New("metrics dump is invalid")
+
+// Export writes all metrics of storage as JSON lines sorted by name, format is the same as of HybridStorage backup file.
+// Dump holds current values only, storages don't keep history of values
+func Export(ctx context.Context, s Repositories, w io.Writer) (int, error) {
+	items, err := s.GetAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+	names := make([]MetricName, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	for _, name := range names {
+		if err := encoder.Encode(&Metrics{
+			ID:          string(name),
+			ActualValue: items[name].GetValue(),
+		}); err != nil {
+			return 0, fmt.Errorf("metric %q: %w", name, err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return len(names), nil
+}
+
+// Import replays metrics written by Export with BatchSet, counters get values of dump rather than being incremented by them
+func Import(ctx context.Context, s Repositories, r io.Reader, validator *NameValidator) (int, error) {
+	decoder := json.NewDecoder(r)
+	batch := make([]Metrics, 0, defaultImportBatchSize)
+	imported := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.BatchSet(ctx, batch); err != nil {
+			return err
+		}
+		imported += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for line := 1; decoder.More(); line++ {
+		var m Metrics
+		if err := decoder.Decode(&m); err != nil {
+			return imported, fmt.Errorf("%w: line %d: %w", ErrDumpInvalid, line, err)
+		}
+		if m.ActualValue == nil {
+			return imported, fmt.Errorf("%w: line %d: metric %q has no value", ErrDumpInvalid, line, m.ID)
+		}
+		if validator != nil {
+			if err := validator.Validate(MetricName(m.ID)); err != nil {
+				return imported, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		batch = append(batch, m)
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return imported, err
+	}
+	return imported, nil
+}
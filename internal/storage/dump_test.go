@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	s := NewMemStorageWithValues(map[MetricName]Metric{
+		"b": NewMetricGauge(float64(1.5)),
+		"a": NewMetricCounter(int64(10)),
+	})
+	var buf bytes.Buffer
+	n, err := Export(context.Background(), s, &buf)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, `{"delta":10,"id":"a","type":"counter"}`+"\n"+`{"value":1.5,"id":"b","type":"gauge"}`+"\n", buf.String())
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	dump := `{"id":"counter","type":"counter","delta":3}
+{"id":"gauge","type":"gauge","value":2.5}
+{"id":"new","type":"counter","delta":7}
+{"id":"retyped","type":"counter","delta":4}
+{"id":"twice","type":"counter","delta":1}
+{"id":"twice","type":"counter","delta":5}
+`
+	tests := []struct {
+		name       string
+		newStorage func(t *testing.T) Repositories
+	}{
+		{name: "memory", newStorage: func(t *testing.T) Repositories { return NewMemStorage() }},
+		//redis collapses counters of batch
+		{name: "redis", newStorage: func(t *testing.T) Repositories {
+			s, _ := newRedisStorage(t)
+			return s
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.newStorage(t)
+			require.NoError(t, s.BatchUpdate(ctx, []Metrics{
+				{ID: "counter", ActualValue: int64(10)},
+				{ID: "gauge", ActualValue: float64(1)},
+				{ID: "retyped", ActualValue: float64(1)},
+				{ID: "twice", ActualValue: int64(2)},
+			}))
+			n, err := Import(ctx, s, strings.NewReader(dump), nil)
+			require.NoError(t, err)
+			require.Equal(t, 6, n)
+			all, err := s.GetAll(ctx)
+			require.NoError(t, err)
+			require.Equal(t, map[MetricName]Metric{
+				"counter": NewMetricCounter(int64(3)),
+				"gauge":   NewMetricGauge(float64(2.5)),
+				"new":     NewMetricCounter(int64(7)),
+				"retyped": NewMetricCounter(int64(4)),
+				"twice":   NewMetricCounter(int64(5)),
+			}, all)
+		})
+	}
+}
+
+func TestImport_Invalid(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	_, err := Import(ctx, s, strings.NewReader(`{"id":"a","type":"counter","delta":1}`+"\n"+`{"id":"b","type":"histogram"}`), nil)
+	require.ErrorIs(t, err, ErrDumpInvalid)
+	require.ErrorContains(t, err, "line 2")
+
+	_, err = Import(ctx, s, strings.NewReader(`{"id":"a b","type":"counter","delta":1}`), NewNameValidator())
+	require.ErrorIs(t, err, ErrMetricNameInvalid)
+	all, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Empty(t, all)
+}
@@ -37,9 +37,7 @@ func NewHybridStorage(ctx context.Context, filename *string, storeInterval *int,
 	encoder := json.NewEncoder(file)
 	decoder := json.NewDecoder(file)
 	storage = &HybridStorage{
-		MemStorage: MemStorage{
-			Values: make(map[MetricName]Metric),
-		},
+		MemStorage:    *NewMemStorage(),
 		file:          file,
 		storeInterval: *storeInterval,
 		lastStored:    time.Time{},
@@ -112,6 +110,7 @@ func (s *HybridStorage) Restore(ctx context.Context) error {
 		return nil
 	}
 	s.backupActive = false
+	var metrics []Metrics
 	for s.decoder.More() {
 		var metric Metrics
 		err := s.decoder.Decode(&metric)
@@ -119,24 +118,29 @@ func (s *HybridStorage) Restore(ctx context.Context) error {
 			return err
 		}
 		switch metric.ActualValue.(type) {
-		case int64:
-			err := s.Insert(ctx, MetricName(metric.ID), NewMetricCounter(metric.ActualValue))
-			if err != nil {
-				return err
-			}
-		case float64:
-			err := s.Insert(ctx, MetricName(metric.ID), NewMetricGauge(metric.ActualValue))
-			if err != nil {
-				return err
-			}
+		case int64, float64:
+			metrics = append(metrics, metric)
 		default:
 			return fmt.Errorf("metric type is unknown")
 		}
 	}
+	//backup holds absolute values, counters must not be added to stored ones
+	if err := s.BatchSet(ctx, metrics); err != nil {
+		return err
+	}
 	s.backupActive = true
 	return nil
 }
 
+// Ping checks backup file is still accessible if backup is active
+func (s *HybridStorage) Ping(ctx context.Context) error {
+	if !s.backupActive {
+		return nil
+	}
+	_, err := s.file.Stat()
+	return err
+}
+
 func (s *HybridStorage) Close(ctx context.Context) error {
 	if s.backupActive {
 		fmt.Println("make final backup before shutdown")
@@ -157,3 +161,11 @@ func (s *HybridStorage) BatchUpdate(ctx context.Context, metrics []Metrics) erro
 	}
 	return s.backupCaller(ctx)
 }
+
+func (s *HybridStorage) BatchSet(ctx context.Context, metrics []Metrics) error {
+	err := s.MemStorage.BatchSet(ctx, metrics)
+	if err != nil {
+		return err
+	}
+	return s.backupCaller(ctx)
+}
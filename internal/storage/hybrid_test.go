@@ -2,18 +2,20 @@ package storage
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestHybridStorage_Get(t *testing.T) {
 	s := HybridStorage{
-		MemStorage: MemStorage{
-			Values: map[MetricName]Metric{
-				"test1": NewMetricCounter(int64(1)),
-				"test2": NewMetricGauge(float64(0.01)),
-			},
-		},
+		MemStorage: *NewMemStorageWithValues(map[MetricName]Metric{
+			"test1": NewMetricCounter(int64(1)),
+			"test2": NewMetricGauge(float64(0.01)),
+		}),
 	}
 	ctx := context.Background()
 
@@ -106,12 +108,10 @@ func TestHybridStorage_Insert(t *testing.T) {
 
 func TestHybridStorage_Update(t *testing.T) {
 	s := HybridStorage{
-		MemStorage: MemStorage{
-			Values: map[MetricName]Metric{
-				"test1": NewMetricCounter(int64(1)),
-				"test2": NewMetricGauge(float64(0.01)),
-			},
-		},
+		MemStorage: *NewMemStorageWithValues(map[MetricName]Metric{
+			"test1": NewMetricCounter(int64(1)),
+			"test2": NewMetricGauge(float64(0.01)),
+		}),
 	}
 	ctx := context.Background()
 
@@ -157,12 +157,10 @@ func TestHybridStorage_Update(t *testing.T) {
 
 func TestHybridStorage_GetAll(t *testing.T) {
 	s := HybridStorage{
-		MemStorage: MemStorage{
-			Values: map[MetricName]Metric{
-				"test1": NewMetricCounter(int64(1)),
-				"test2": NewMetricGauge(float64(0.01)),
-			},
-		},
+		MemStorage: *NewMemStorageWithValues(map[MetricName]Metric{
+			"test1": NewMetricCounter(int64(1)),
+			"test2": NewMetricGauge(float64(0.01)),
+		}),
 	}
 	want := map[MetricName]Metric{
 		"test1": NewMetricCounter(int64(1)),
@@ -218,6 +216,36 @@ func TestHybridStorage_BatchUpdate(t *testing.T) {
 	}
 }
 
+func TestHybridStorage_Restore(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "backup.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"id":"test","type":"counter","delta":5}
+{"id":"test","type":"counter","delta":7}
+{"id":"gtest","type":"gauge","value":0.5}
+`), 0644))
+	restore := true
+	storeInterval := 300
+
+	s, err := NewHybridStorage(ctx, &filename, &storeInterval, &restore)
+	require.NoError(t, err)
+	//counters of backup are absolute values
+	all, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[MetricName]Metric{
+		"test":  NewMetricCounter(int64(7)),
+		"gtest": NewMetricGauge(float64(0.5)),
+	}, all)
+	require.NoError(t, s.Close(ctx))
+
+	//restored values are not doubled by next start
+	s, err = NewHybridStorage(ctx, &filename, &storeInterval, &restore)
+	require.NoError(t, err)
+	m, err := s.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(7)), m)
+	require.NoError(t, s.Close(ctx))
+}
+
 func TestHybridStorage_Close(t *testing.T) {
 	ctx := context.Background()
 	filename := ""
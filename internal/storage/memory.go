@@ -3,79 +3,207 @@ package storage
 import (
 	"context"
 	"fmt"
+	"hash/maphash"
 	"sync"
 )
 
+// memShardCount number of MemStorage shards, power of two
+const memShardCount int = 32
+
+// memShard part of metrics guarded by its own lock
+type memShard struct {
+	values map[MetricName]Metric
+	mu     sync.RWMutex
+}
+
+// MemStorage keeps metrics in memory split into shards by name hash, so writers of different metrics
+// don't wait for each other. Stored metrics never leave storage, readers get copies
 type MemStorage struct {
-	Values map[MetricName]Metric
-	mu     sync.Mutex
+	shards []*memShard
+	seed   maphash.Seed
 }
 
 func NewMemStorage() *MemStorage {
-	return &MemStorage{Values: make(map[MetricName]Metric)}
+	s := &MemStorage{
+		shards: make([]*memShard, memShardCount),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range s.shards {
+		s.shards[i] = &memShard{values: make(map[MetricName]Metric)}
+	}
+	return s
+}
+
+// NewMemStorageWithValues is factory method for storage filled with values, values map is not retained
+func NewMemStorageWithValues(values map[MetricName]Metric) *MemStorage {
+	s := NewMemStorage()
+	for key, m := range values {
+		s.shard(key).values[key] = cloneMetric(m)
+	}
+	return s
+}
+
+// shard returns shard metric belongs to
+func (s *MemStorage) shard(key MetricName) *memShard {
+	return s.shards[s.shardIndex(key)]
+}
+
+func (s *MemStorage) shardIndex(key MetricName) int {
+	return int(maphash.String(s.seed, string(key)) & uint64(len(s.shards)-1))
 }
 
 func (s *MemStorage) Get(ctx context.Context, key MetricName) (Metric, error) {
-	s.mu.Lock()
-	if val, ok := s.Values[key]; ok {
-		s.mu.Unlock()
-		return val, nil
+	sh := s.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if val, ok := sh.values[key]; ok {
+		return cloneMetric(val), nil
 	}
-	s.mu.Unlock()
 	return nil, nil
 }
 
 func (s *MemStorage) Insert(ctx context.Context, key MetricName, m Metric) error {
-	s.mu.Lock()
-	s.Values[key] = m
-	s.mu.Unlock()
+	if m == nil {
+		return fmt.Errorf("metric is nil")
+	}
+	sh := s.shard(key)
+	sh.mu.Lock()
+	sh.values[key] = cloneMetric(m)
+	sh.mu.Unlock()
 	return nil
 }
 
+// Update changes stored metric by v and sets metric to new stored value
 func (s *MemStorage) Update(ctx context.Context, key MetricName, v interface{}, metric Metric) error {
-	s.mu.Lock()
-	m, ok := s.Values[key]
+	if v == nil {
+		return fmt.Errorf("value is nil")
+	}
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	m, ok := sh.values[key]
 	if !ok {
-		s.mu.Unlock()
 		return fmt.Errorf("key is not found in storage")
 	}
-	if v == nil {
-		return fmt.Errorf("value is nil")
+	if !sameType(m, v) {
+		return fmt.Errorf("value type doesn't match metric type")
 	}
 	m.UpdateValue(v)
-	s.mu.Unlock()
+	if metric != nil {
+		setMetricValue(metric, m.GetValue())
+	}
 	return nil
 }
 
+// GetAll returns copy of all metrics, all shards are locked while copying,
+// so snapshot doesn't contain half applied batch
 func (s *MemStorage) GetAll(ctx context.Context) (map[MetricName]Metric, error) {
-	return s.Values, nil
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+	}
+	defer func() {
+		for _, sh := range s.shards {
+			sh.mu.RUnlock()
+		}
+	}()
+	size := 0
+	for _, sh := range s.shards {
+		size += len(sh.values)
+	}
+	metrics := make(map[MetricName]Metric, size)
+	for _, sh := range s.shards {
+		for key, m := range sh.values {
+			metrics[key] = cloneMetric(m)
+		}
+	}
+	return metrics, nil
+}
+
+// Ping memory is always available
+func (s *MemStorage) Ping(ctx context.Context) error {
+	return nil
 }
 
 func (s *MemStorage) Close(ctx context.Context) error {
 	return nil
 }
 
+// BatchUpdate applies metrics holding locks of all affected shards, so batch is seen by GetAll as a whole.
+// Shards are locked in index order, concurrent batches can't deadlock
 func (s *MemStorage) BatchUpdate(ctx context.Context, metrics []Metrics) error {
+	return s.applyBatch(metrics, false)
+}
+
+// BatchSet replaces values of metrics, batch is applied like in BatchUpdate
+func (s *MemStorage) BatchSet(ctx context.Context, metrics []Metrics) error {
+	return s.applyBatch(metrics, true)
+}
+
+// applyBatch adds counters to stored values or replaces them if set is true
+func (s *MemStorage) applyBatch(metrics []Metrics, set bool) error {
+	for _, m := range metrics {
+		switch m.ActualValue.(type) {
+		case int64, float64:
+		default:
+			return fmt.Errorf("metric type unknown in batch update")
+		}
+	}
+	locked := make([]bool, len(s.shards))
 	for _, m := range metrics {
-		metric, err := s.Get(ctx, MetricName(m.ID))
-		if err != nil {
-			return err
+		locked[s.shardIndex(MetricName(m.ID))] = true
+	}
+	for i, sh := range s.shards {
+		if locked[i] {
+			sh.mu.Lock()
 		}
-		if metric != nil {
-			err = s.Update(ctx, MetricName(m.ID), m.ActualValue, metric)
-		} else {
-			switch val := m.ActualValue.(type) {
-			case int64:
-				err = s.Insert(ctx, MetricName(m.ID), NewMetricCounter(val))
-			case float64:
-				err = s.Insert(ctx, MetricName(m.ID), NewMetricGauge(val))
-			default:
-				err = fmt.Errorf("metric type unknown in batch update")
+	}
+	defer func() {
+		for i, sh := range s.shards {
+			if locked[i] {
+				sh.mu.Unlock()
 			}
 		}
-		if err != nil {
-			return err
+	}()
+	for _, m := range metrics {
+		key := MetricName(m.ID)
+		sh := s.shard(key)
+		if stored, ok := sh.values[key]; ok && !set && sameType(stored, m.ActualValue) {
+			stored.UpdateValue(m.ActualValue)
+			continue
+		}
+		switch val := m.ActualValue.(type) {
+		case int64:
+			sh.values[key] = NewMetricCounter(val)
+		case float64:
+			sh.values[key] = NewMetricGauge(val)
 		}
 	}
 	return nil
 }
+
+// sameType reports whether v is value of metric type
+func sameType(m Metric, v interface{}) bool {
+	switch v.(type) {
+	case int64:
+		_, ok := m.(*MetricCounter)
+		return ok
+	case float64:
+		_, ok := m.(*MetricGauge)
+		return ok
+	}
+	return false
+}
+
+// setMetricValue sets absolute value of metric, value of other type is ignored
+func setMetricValue(m Metric, v interface{}) {
+	switch metric := m.(type) {
+	case *MetricCounter:
+		if val, ok := v.(int64); ok {
+			metric.val = val
+		}
+	case *MetricGauge:
+		if val, ok := v.(float64); ok {
+			metric.val = val
+		}
+	}
+}
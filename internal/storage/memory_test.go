@@ -3,16 +3,17 @@ package storage
 import (
 	"context"
 	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
 func TestMemStorage_Get(t *testing.T) {
-	s := MemStorage{
-		Values: map[MetricName]Metric{
-			"test1": NewMetricCounter(int64(1)),
-			"test2": NewMetricGauge(float64(0.01)),
-		},
-	}
+	s := NewMemStorageWithValues(map[MetricName]Metric{
+		"test1": NewMetricCounter(int64(1)),
+		"test2": NewMetricGauge(float64(0.01)),
+	})
 	ctx := context.Background()
 
 	type args struct {
@@ -96,12 +97,10 @@ func TestMemStorage_Insert(t *testing.T) {
 }
 
 func TestMemStorage_Update(t *testing.T) {
-	s := MemStorage{
-		Values: map[MetricName]Metric{
-			"test1": NewMetricCounter(int64(1)),
-			"test2": NewMetricGauge(float64(0.01)),
-		},
-	}
+	s := NewMemStorageWithValues(map[MetricName]Metric{
+		"test1": NewMetricCounter(int64(1)),
+		"test2": NewMetricGauge(float64(0.01)),
+	})
 	ctx := context.Background()
 
 	type args struct {
@@ -145,12 +144,10 @@ func TestMemStorage_Update(t *testing.T) {
 }
 
 func TestMemStorage_GetAll(t *testing.T) {
-	s := MemStorage{
-		Values: map[MetricName]Metric{
-			"test1": NewMetricCounter(int64(1)),
-			"test2": NewMetricGauge(float64(0.01)),
-		},
-	}
+	s := NewMemStorageWithValues(map[MetricName]Metric{
+		"test1": NewMetricCounter(int64(1)),
+		"test2": NewMetricGauge(float64(0.01)),
+	})
 	want := map[MetricName]Metric{
 		"test1": NewMetricCounter(int64(1)),
 		"test2": NewMetricGauge(float64(0.01)),
@@ -195,9 +192,196 @@ func TestMemStorage_BatchUpdate(t *testing.T) {
 
 }
 
+func TestMemStorage_BatchSet(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorageWithValues(map[MetricName]Metric{
+		"test":    NewMetricCounter(int64(10)),
+		"changed": NewMetricGauge(float64(1)),
+	})
+	err := s.BatchSet(ctx, []Metrics{
+		{ID: "test", ActualValue: int64(3)},
+		{ID: "changed", ActualValue: int64(2)},
+		//last value of duplicates wins
+		{ID: "twice", ActualValue: int64(1)},
+		{ID: "twice", ActualValue: int64(5)},
+	})
+	if err != nil {
+		t.Fatalf("MemStorage.BatchSet() error = %v", err)
+	}
+	want := map[MetricName]Metric{
+		"test":    NewMetricCounter(int64(3)),
+		"changed": NewMetricCounter(int64(2)),
+		"twice":   NewMetricCounter(int64(5)),
+	}
+	if got, _ := s.GetAll(ctx); !reflect.DeepEqual(got, want) {
+		t.Errorf("MemStorage.GetAll() = %v, want %v", got, want)
+	}
+	if err := s.BatchSet(ctx, []Metrics{{ID: "bad", ActualValue: "1"}}); err == nil {
+		t.Errorf("batch with unknown metric type must fail")
+	}
+}
+
 func TestMemStorage_Close(t *testing.T) {
 	s := NewMemStorage()
 	if err := s.Close(context.Background()); err != nil {
 		t.Errorf("MemStorage.BatchUpdate() error = %v", err)
 	}
 }
+
+// storedMetric reads metric from storage failing test on error
+func storedMetric(t *testing.T, s Repositories, key MetricName) Metric {
+	t.Helper()
+	m, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	return m
+}
+
+func TestMemStorage_GetAllSnapshot(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorageWithValues(map[MetricName]Metric{
+		"test1": NewMetricCounter(int64(1)),
+	})
+	got, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("MemStorage.GetAll() error = %v", err)
+	}
+	//changes of snapshot don't affect storage and vice versa
+	got["test1"].UpdateValue(int64(10))
+	got["test2"] = NewMetricGauge(float64(1))
+	if err := s.Update(ctx, "test1", int64(1), nil); err != nil {
+		t.Fatalf("MemStorage.Update() error = %v", err)
+	}
+	if m := storedMetric(t, s, "test1"); !reflect.DeepEqual(m, NewMetricCounter(int64(2))) {
+		t.Errorf("stored metric = %v, want 2", m)
+	}
+	if m := storedMetric(t, s, "test2"); m != nil {
+		t.Errorf("metric added to snapshot is found in storage")
+	}
+	if got["test1"].GetValue() != int64(11) {
+		t.Errorf("snapshot metric = %v, want 11", got["test1"])
+	}
+}
+
+func TestMemStorage_UpdateCallerMetric(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorageWithValues(map[MetricName]Metric{
+		"test1": NewMetricCounter(int64(5)),
+	})
+	//caller's copy may be stale, after update it holds stored value
+	stale := NewMetricCounter(int64(1))
+	if err := s.Update(ctx, "test1", int64(2), stale); err != nil {
+		t.Fatalf("MemStorage.Update() error = %v", err)
+	}
+	if !reflect.DeepEqual(stale, NewMetricCounter(int64(7))) {
+		t.Errorf("caller metric = %v, want 7", stale)
+	}
+	if err := s.Update(ctx, "test1", float64(1), stale); err == nil {
+		t.Errorf("update of counter by gauge value must fail")
+	}
+}
+
+func TestMemStorage_ConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	const writers, updates = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				err := s.BatchUpdate(ctx, []Metrics{
+					{ID: "counter", ActualValue: int64(1)},
+					{ID: "gauge" + strconv.Itoa(i%10), ActualValue: float64(i)},
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		//readers iterate snapshots while writers update storage
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				all, err := s.GetAll(ctx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for _, m := range all {
+					_ = m.String()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if m := storedMetric(t, s, "counter"); !reflect.DeepEqual(m, NewMetricCounter(int64(writers*updates))) {
+		t.Errorf("counter = %v, want %d", m, writers*updates)
+	}
+}
+
+func BenchmarkMemStorage_ParallelUpdate(b *testing.B) {
+	for _, names := range []int{1, 100, 10000} {
+		b.Run(strconv.Itoa(names)+" metrics", func(b *testing.B) {
+			ctx := context.Background()
+			s := NewMemStorage()
+			keys := make([]MetricName, names)
+			for i := range keys {
+				keys[i] = MetricName("metric" + strconv.Itoa(i))
+				if err := s.Insert(ctx, keys[i], NewMetricCounter(int64(0))); err != nil {
+					b.Fatal(err)
+				}
+			}
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1))
+				for pb.Next() {
+					if err := s.Update(ctx, keys[i%names], int64(1), nil); err != nil {
+						b.Error(err)
+						return
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkMemStorage_ParallelMixed(b *testing.B) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	const names = 1000
+	keys := make([]MetricName, names)
+	for i := range keys {
+		keys[i] = MetricName("metric" + strconv.Itoa(i))
+		if err := s.Insert(ctx, keys[i], NewMetricGauge(float64(0))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	var next atomic.Int64
+	b.ResetTimer()
+	//every 10th operation is write, every 1000th is snapshot
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(next.Add(1))
+		for pb.Next() {
+			var err error
+			switch {
+			case i%1000 == 0:
+				_, err = s.GetAll(ctx)
+			case i%10 == 0:
+				err = s.Update(ctx, keys[i%names], float64(i), nil)
+			default:
+				_, err = s.Get(ctx, keys[i%names])
+			}
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
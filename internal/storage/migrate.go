@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/esafronov/yp-metrics/internal/logger"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+const migrationsTableName string = "schema_migrations"
+
+var ErrIrreversibleMigration = errors.New("migration can't be reverted")
+
+// migrationLockID key of postgres advisory lock, held while migrations are running
+const migrationLockID int64 = 7_302_118_001
+
+// Migration is a numbered schema change with statements for applying and reverting it
+type Migration struct {
+	Name      string   //short description
+	Up        []string //statements to apply migration
+	Down      []string //statements to revert migration, migration without them can't be reverted
+	DownCheck string   //query counting rows which don't fit reverted schema, migration isn't reverted if there are any
+	Version   int64    //unique version number, migrations are applied in ascending order
+}
+
+// migrations list of DBStorage schema migrations, append new ones to the end with next version number
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create metrics table",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS ` + tableName + `(
+				id SERIAL,
+				metric_name VARCHAR(30) NOT NULL,
+				metric_type VARCHAR(7) NOT NULL,
+				value_gauge DOUBLE PRECISION DEFAULT NULL,
+				value_counter BIGINT DEFAULT NULL
+			)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS metric_name ON ` + tableName + ` (metric_name)`,
+		},
+		//baseline isn't reverted, it would drop all metrics
+	},
+	{
+		Version: 2,
+		Name:    "extend metric name length",
+		Up: []string{
+			`ALTER TABLE ` + tableName + ` ALTER COLUMN metric_name TYPE VARCHAR(255)`,
+		},
+		Down: []string{
+			`ALTER TABLE ` + tableName + ` ALTER COLUMN metric_name TYPE VARCHAR(30)`,
+		},
+		DownCheck: `SELECT count(*) FROM ` + tableName + ` WHERE length(metric_name) > 30`,
+	},
+}
+
+// Migrator applies and reverts DBStorage schema migrations
+type Migrator struct {
+	db         PgxPool
+	migrations []Migration
+}
+
+// NewMigrator is factory method
+func NewMigrator(db PgxPool) *Migrator {
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}
+}
+
+// Up applies all pending migrations, returns number of applied migrations
+func (m *Migrator) Up(ctx context.Context) (applied int, err error) {
+	err = m.withLock(ctx, func(tx pgx.Tx) error {
+		versions, err := m.appliedVersions(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			if versions[mg.Version] {
+				continue
+			}
+			if err := m.apply(ctx, tx, mg, mg.Up,
+				"INSERT INTO "+migrationsTableName+"(version, name) VALUES ($1, $2)", mg.Version, mg.Name); err != nil {
+				return err
+			}
+			logger.Log.Info("migration applied", zap.Int64("version", mg.Version), zap.String("name", mg.Name))
+			applied++
+		}
+		return nil
+	})
+	return
+}
+
+// Down reverts last applied migrations, steps limits number of reverted migrations.
+// Nothing is reverted if any of migrations can't be reverted or stored data doesn't fit reverted schema
+func (m *Migrator) Down(ctx context.Context, steps int) (reverted int, err error) {
+	err = m.withLock(ctx, func(tx pgx.Tx) error {
+		versions, err := m.appliedVersions(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			mg := m.migrations[i]
+			if !versions[mg.Version] {
+				continue
+			}
+			if err := m.checkDown(ctx, tx, mg); err != nil {
+				return err
+			}
+			if err := m.apply(ctx, tx, mg, mg.Down,
+				"DELETE FROM "+migrationsTableName+" WHERE version=$1", mg.Version); err != nil {
+				return err
+			}
+			logger.Log.Info("migration reverted", zap.Int64("version", mg.Version), zap.String("name", mg.Name))
+			reverted++
+		}
+		return nil
+	})
+	if err != nil {
+		//transaction is rolled back
+		return 0, err
+	}
+	return
+}
+
+// Version returns last applied migration version, 0 if there are no applied migrations
+func (m *Migrator) Version(ctx context.Context) (version int64, err error) {
+	err = m.withLock(ctx, func(tx pgx.Tx) error {
+		versions, err := m.appliedVersions(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for v := range versions {
+			if v > version {
+				version = v
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// withLock runs f in one transaction holding advisory lock, so concurrent migrators wait for each other.
+// Postgres DDL is transactional, so failed run leaves schema untouched
+func (m *Migrator) withLock(ctx context.Context, f func(tx pgx.Tx) error) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// roll back if commit will fail
+	defer func() {
+		err := tx.Rollback(context.Background())
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.Log.Info(err.Error())
+		}
+	}()
+	if _, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+migrationsTableName+`(
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// appliedVersions reads set of applied migration versions
+func (m *Migrator) appliedVersions(ctx context.Context, tx pgx.Tx) (map[int64]bool, error) {
+	rows, err := tx.Query(ctx, "SELECT version FROM "+migrationsTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := map[int64]bool{}
+	var version int64
+	for rows.Next() {
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions[version] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// checkDown checks migration can be reverted
+func (m *Migrator) checkDown(ctx context.Context, tx pgx.Tx, mg Migration) error {
+	if len(mg.Down) == 0 {
+		return fmt.Errorf("migration %d %q: %w", mg.Version, mg.Name, ErrIrreversibleMigration)
+	}
+	if mg.DownCheck == "" {
+		return nil
+	}
+	var rows int64
+	if err := tx.QueryRow(ctx, mg.DownCheck).Scan(&rows); err != nil {
+		return fmt.Errorf("migration %d %q: %w", mg.Version, mg.Name, err)
+	}
+	if rows > 0 {
+		return fmt.Errorf("migration %d %q: %w, %d rows don't fit reverted schema", mg.Version, mg.Name, ErrIrreversibleMigration, rows)
+	}
+	return nil
+}
+
+// apply executes migration statements and bookkeeping query
+func (m *Migrator) apply(ctx context.Context, tx pgx.Tx, mg Migration, statements []string, query string, args ...any) error {
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("migration %d %q: %w", mg.Version, mg.Name, err)
+		}
+	}
+	_, err := tx.Exec(ctx, query, args...)
+	return err
+}
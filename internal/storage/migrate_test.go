@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func expectMigrationLock(mock pgxmock.PgxPoolIface) {
+	mock.ExpectBegin()
+	mock.ExpectExec("^SELECT pg_advisory_xact_lock").
+		WithArgs(migrationLockID).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mock.ExpectExec("^CREATE TABLE IF NOT EXISTS " + migrationsTableName).WithArgs().
+		WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+}
+
+func TestMigrator_Up(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	m := NewMigrator(mock)
+
+	expectMigrationLock(mock)
+	//first migration is already applied
+	mock.ExpectQuery("^SELECT version FROM " + migrationsTableName).
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(int64(1)))
+	mock.ExpectExec("^ALTER TABLE " + tableName).WithArgs().WillReturnResult(pgxmock.NewResult("ALTER TABLE", 0))
+	mock.ExpectExec("^INSERT INTO "+migrationsTableName).
+		WithArgs(int64(2), "extend metric name length").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+
+	applied, err := m.Up(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, applied, "должна быть применена одна миграция")
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMigrator_Down(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	m := NewMigrator(mock)
+
+	expectMigrationLock(mock)
+	mock.ExpectQuery("^SELECT version FROM " + migrationsTableName).
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(int64(1)).AddRow(int64(2)))
+	mock.ExpectQuery("^SELECT count").WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(0)))
+	mock.ExpectExec("^ALTER TABLE " + tableName).WithArgs().WillReturnResult(pgxmock.NewResult("ALTER TABLE", 0))
+	mock.ExpectExec("^DELETE FROM " + migrationsTableName).
+		WithArgs(int64(2)).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectCommit()
+
+	reverted, err := m.Down(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, reverted, "должна быть откачена одна миграция")
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMigrator_DownRefused(t *testing.T) {
+	tests := []struct {
+		prepare func(mock pgxmock.PgxPoolIface)
+		name    string
+		wantErr string
+	}{
+		{
+			name: "baseline",
+			prepare: func(mock pgxmock.PgxPoolIface) {
+				mock.ExpectQuery("^SELECT count").WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(0)))
+				mock.ExpectExec("^ALTER TABLE " + tableName).WithArgs().WillReturnResult(pgxmock.NewResult("ALTER TABLE", 0))
+				mock.ExpectExec("^DELETE FROM " + migrationsTableName).
+					WithArgs(int64(2)).
+					WillReturnResult(pgxmock.NewResult("DELETE", 1))
+			},
+			wantErr: `migration 1 "create metrics table"`,
+		},
+		{
+			name: "long metric names",
+			prepare: func(mock pgxmock.PgxPoolIface) {
+				mock.ExpectQuery("^SELECT count").WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(3)))
+			},
+			wantErr: "3 rows don't fit reverted schema",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, err := pgxmock.NewPool()
+			require.NoError(t, err)
+			m := NewMigrator(mock)
+
+			expectMigrationLock(mock)
+			mock.ExpectQuery("^SELECT version FROM " + migrationsTableName).
+				WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(int64(1)).AddRow(int64(2)))
+			tt.prepare(mock)
+			//nothing is reverted
+			mock.ExpectRollback()
+
+			reverted, err := m.Down(context.Background(), 2)
+			require.ErrorIs(t, err, ErrIrreversibleMigration)
+			require.ErrorContains(t, err, tt.wantErr)
+			require.Equal(t, 0, reverted)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMigrator_Version(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	m := NewMigrator(mock)
+
+	expectMigrationLock(mock)
+	mock.ExpectQuery("^SELECT version FROM " + migrationsTableName).
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(int64(2)).AddRow(int64(1)))
+	mock.ExpectCommit()
+
+	version, err := m.Version(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), version)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxMetricNameLength max length of metric name stored by DBStorage
+const MaxMetricNameLength int = 255
+
+var ErrMetricNameInvalid = errors.New("metric name is invalid")
+
+// validNameChars allowed characters of metric name
+var validNameChars = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// NameValidator checks metric names before they are stored
+type NameValidator struct {
+	reserved  []string //prefixes of names used by server itself
+	maxLength int
+}
+
+// OptionWithMaxNameLength option function to configure max length of metric name
+func OptionWithMaxNameLength(n int) func(v *NameValidator) {
+	return func(v *NameValidator) {
+		if n > 0 {
+			v.maxLength = n
+		}
+	}
+}
+
+// OptionWithReservedPrefixes option function to configure prefixes which names can't start with
+func OptionWithReservedPrefixes(prefixes ...string) func(v *NameValidator) {
+	return func(v *NameValidator) {
+		for _, p := range prefixes {
+			if p != "" {
+				v.reserved = append(v.reserved, p)
+			}
+		}
+	}
+}
+
+// NewNameValidator is factory method, names are limited by MaxMetricNameLength by default
+func NewNameValidator(opts ...func(v *NameValidator)) *NameValidator {
+	v := &NameValidator{maxLength: MaxMetricNameLength}
+	for _, f := range opts {
+		f(v)
+	}
+	return v
+}
+
+// Validate returns ErrMetricNameInvalid wrapped with reason if name is not allowed
+func (v *NameValidator) Validate(name MetricName) error {
+	if len(name) > v.maxLength {
+		return fmt.Errorf("%w: %q is longer than %d characters", ErrMetricNameInvalid, name, v.maxLength)
+	}
+	if !validNameChars.MatchString(string(name)) {
+		return fmt.Errorf("%w: %q may contain only letters, digits and _.:- characters", ErrMetricNameInvalid, name)
+	}
+	for _, p := range v.reserved {
+		if strings.HasPrefix(string(name), p) {
+			return fmt.Errorf("%w: %q has reserved prefix %q", ErrMetricNameInvalid, name, p)
+		}
+	}
+	return nil
+}
+
+// batchNames returns names of metrics in batch
+func batchNames(metrics []Metrics) []MetricName {
+	names := make([]MetricName, 0, len(metrics))
+	for _, m := range metrics {
+		names = append(names, MetricName(m.ID))
+	}
+	return names
+}
+
+// nameSet set of known metric names, new names are reserved by writes in progress
+// and stay known only if write is applied
+type nameSet struct {
+	stored  map[MetricName]bool //names of stored metrics
+	pending map[MetricName]int  //number of writes in progress reserved not stored name
+}
+
+// newNameSet returns set of names of stored metrics
+func newNameSet(metrics map[MetricName]Metric) *nameSet {
+	s := &nameSet{
+		stored:  make(map[MetricName]bool, len(metrics)),
+		pending: make(map[MetricName]int),
+	}
+	for name := range metrics {
+		s.stored[name] = true
+	}
+	return s
+}
+
+// reserve reserves names if number of known and new names doesn't exceed limit, otherwise no names are reserved.
+// Returns not stored names which are passed to done when write is finished
+func (s *nameSet) reserve(names []MetricName, limit int) ([]MetricName, bool) {
+	known := len(s.stored) + len(s.pending)
+	var reserved []MetricName
+	added := make(map[MetricName]bool)
+	for _, name := range names {
+		if s.stored[name] || added[name] {
+			continue
+		}
+		added[name] = true
+		reserved = append(reserved, name)
+		if s.pending[name] == 0 {
+			known++
+		}
+	}
+	if known > limit {
+		return nil, false
+	}
+	for _, name := range reserved {
+		s.pending[name]++
+	}
+	return reserved, true
+}
+
+// done releases names reserved by write, names are stored if write is applied
+func (s *nameSet) done(reserved []MetricName, applied bool) {
+	for _, name := range reserved {
+		if applied {
+			s.stored[name] = true
+			delete(s.pending, name)
+			continue
+		}
+		if s.pending[name]--; s.pending[name] <= 0 {
+			delete(s.pending, name)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameValidator_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []func(v *NameValidator)
+		metric  MetricName
+		wantErr bool
+	}{
+		{name: "runtime metric", metric: "HeapAlloc"},
+		{name: "allowed characters", metric: "cpu.user_time:total-1"},
+		{name: "max length", metric: MetricName(strings.Repeat("a", MaxMetricNameLength))},
+		{name: "too long", metric: MetricName(strings.Repeat("a", MaxMetricNameLength+1)), wantErr: true},
+		{name: "configured length", opts: []func(v *NameValidator){OptionWithMaxNameLength(3)}, metric: "abcd", wantErr: true},
+		{name: "space", metric: "heap alloc", wantErr: true},
+		{name: "tenant separator", metric: "team/alloc", wantErr: true},
+		{name: "non ascii", metric: "память", wantErr: true},
+		{name: "empty", metric: "", wantErr: true},
+		{name: "reserved prefix", opts: []func(v *NameValidator){OptionWithReservedPrefixes("server.", "")}, metric: "server.uptime", wantErr: true},
+		{name: "not reserved prefix", opts: []func(v *NameValidator){OptionWithReservedPrefixes("server.")}, metric: "serveruptime"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewNameValidator(tt.opts...).Validate(tt.metric)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrMetricNameInvalid)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNameSet_reserve(t *testing.T) {
+	s := newNameSet(map[MetricName]Metric{"stored": NewMetricCounter(int64(1))})
+
+	first, ok := s.reserve([]MetricName{"stored", "a", "a"}, 3)
+	require.True(t, ok)
+	require.Equal(t, []MetricName{"a"}, first)
+	//names reserved by writes in progress are counted
+	_, ok = s.reserve([]MetricName{"b", "c"}, 3)
+	require.False(t, ok)
+	second, ok := s.reserve([]MetricName{"a", "b"}, 3)
+	require.True(t, ok)
+
+	//name stays reserved by other write after failed one
+	s.done(first, false)
+	_, ok = s.reserve([]MetricName{"c"}, 3)
+	require.False(t, ok)
+	s.done(second, true)
+	_, ok = s.reserve([]MetricName{"c"}, 3)
+	require.False(t, ok)
+
+	failed, ok := s.reserve([]MetricName{"c"}, 4)
+	require.True(t, ok)
+	s.done(failed, false)
+	require.Equal(t, map[MetricName]bool{"stored": true, "a": true, "b": true}, s.stored)
+	require.Empty(t, s.pending)
+}
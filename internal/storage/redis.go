@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/esafronov/yp-metrics/internal/resp"
+)
+
+// defaultRedisKeyPrefix prefix of hash keys, counters and gauges are kept in separate hashes
+const defaultRedisKeyPrefix string = "metrics:"
+
+// RedisStorage keeps metrics in Redis hashes, so several server instances can share one metric set.
+// Counters are added with HINCRBY and gauges are replaced with HSET, metric name is hash field
+type RedisStorage struct {
+	client     *resp.Client
+	counterKey string
+	gaugeKey   string
+}
+
+// OptionWithRedisKeyPrefix option function to configure RedisStorage hash key prefix
+func OptionWithRedisKeyPrefix(prefix string) func(s *RedisStorage) {
+	return func(s *RedisStorage) {
+		s.counterKey = prefix + string(MetricTypeCounter)
+		s.gaugeKey = prefix + string(MetricTypeGauge)
+	}
+}
+
+// NewRedisStorage is factory method, storage takes ownership of client and closes it on Close
+func NewRedisStorage(client *resp.Client, opts ...func(s *RedisStorage)) *RedisStorage {
+	s := &RedisStorage{
+		client: client,
+	}
+	OptionWithRedisKeyPrefix(defaultRedisKeyPrefix)(s)
+	for _, f := range opts {
+		f(s)
+	}
+	return s
+}
+
+func (s *RedisStorage) Get(ctx context.Context, key MetricName) (Metric, error) {
+	replies, err := s.client.Pipeline(ctx, [][]string{
+		{"HGET", s.gaugeKey, string(key)},
+		{"HGET", s.counterKey, string(key)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = replyError(replies); err != nil {
+		return nil, err
+	}
+	if v, ok := replies[0].(string); ok {
+		return parseGauge(v)
+	}
+	if v, ok := replies[1].(string); ok {
+		return parseCounter(v)
+	}
+	return nil, nil
+}
+
+func (s *RedisStorage) Insert(ctx context.Context, key MetricName, m Metric) error {
+	if m == nil {
+		return fmt.Errorf("metric is nil")
+	}
+	return s.exec(ctx, []Metrics{{ID: string(key), ActualValue: m.GetValue()}}, false)
+}
+
+func (s *RedisStorage) Update(ctx context.Context, key MetricName, v interface{}, metric Metric) error {
+	switch v.(type) {
+	case int64, float64:
+		if err := s.exec(ctx, []Metrics{{ID: string(key), ActualValue: v}}, false); err != nil {
+			return err
+		}
+	}
+	metric.UpdateValue(v)
+	return nil
+}
+
+// BatchUpdate sends all metrics in one pipelined transaction
+func (s *RedisStorage) BatchUpdate(ctx context.Context, metrics []Metrics) error {
+	rows, err := collapseBatch(metrics)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return s.exec(ctx, rows, false)
+}
+
+// BatchSet sends all metrics in one pipelined transaction, stored counters are replaced
+func (s *RedisStorage) BatchSet(ctx context.Context, metrics []Metrics) error {
+	rows, err := collapseSet(metrics)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return s.exec(ctx, rows, true)
+}
+
+// exec writes metrics in MULTI/EXEC block, metric is removed from hash of other type,
+// so name always has one type like in other storages. Counters are replaced if set is true
+func (s *RedisStorage) exec(ctx context.Context, metrics []Metrics, set bool) error {
+	cmds := make([][]string, 0, len(metrics)*2+2)
+	cmds = append(cmds, []string{"MULTI"})
+	for _, m := range metrics {
+		switch val := m.ActualValue.(type) {
+		case int64:
+			op := "HINCRBY"
+			if set {
+				op = "HSET"
+			}
+			cmds = append(cmds,
+				[]string{op, s.counterKey, m.ID, strconv.FormatInt(val, 10)},
+				[]string{"HDEL", s.gaugeKey, m.ID})
+		case float64:
+			cmds = append(cmds,
+				[]string{"HSET", s.gaugeKey, m.ID, strconv.FormatFloat(val, 'f', -1, 64)},
+				[]string{"HDEL", s.counterKey, m.ID})
+		default:
+			return fmt.Errorf("metric type is unknown")
+		}
+	}
+	cmds = append(cmds, []string{"EXEC"})
+	replies, err := s.client.Pipeline(ctx, cmds)
+	if err != nil {
+		return err
+	}
+	if err = replyError(replies); err != nil {
+		return err
+	}
+	//EXEC reply holds results of queued commands, nil if transaction was aborted
+	results, ok := replies[len(replies)-1].([]any)
+	if !ok {
+		return fmt.Errorf("redis transaction is aborted")
+	}
+	return replyError(results)
+}
+
+func (s *RedisStorage) GetAll(ctx context.Context) (map[MetricName]Metric, error) {
+	replies, err := s.client.Pipeline(ctx, [][]string{
+		{"HGETALL", s.counterKey},
+		{"HGETALL", s.gaugeKey},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = replyError(replies); err != nil {
+		return nil, err
+	}
+	metrics := map[MetricName]Metric{}
+	for i, parse := range []func(string) (Metric, error){parseCounter, parseGauge} {
+		items, _ := replies[i].([]any)
+		for j := 0; j+1 < len(items); j += 2 {
+			name, _ := items[j].(string)
+			v, _ := items[j+1].(string)
+			m, err := parse(v)
+			if err != nil {
+				return nil, err
+			}
+			//gauge wins if name is present in both hashes, as in Get
+			metrics[MetricName(name)] = m
+		}
+	}
+	return metrics, nil
+}
+
+// Ping checks redis is reachable
+func (s *RedisStorage) Ping(ctx context.Context) error {
+	_, err := s.client.Do(ctx, "PING")
+	return err
+}
+
+func (s *RedisStorage) Close(ctx context.Context) error {
+	return s.client.Close()
+}
+
+// replyError returns first error reply
+func replyError(replies []any) error {
+	for _, r := range replies {
+		if e, ok := r.(resp.Error); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+func parseGauge(v string) (Metric, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("gauge value %q: %w", v, err)
+	}
+	return NewMetricGauge(f), nil
+}
+
+func parseCounter(v string) (Metric, error) {
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("counter value %q: %w", v, err)
+	}
+	return NewMetricCounter(i), nil
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/esafronov/yp-metrics/internal/resp"
+	"github.com/esafronov/yp-metrics/internal/resp/resptest"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedisStorage(t *testing.T) (*RedisStorage, *resptest.Server) {
+	srv := resptest.NewServer()
+	t.Cleanup(srv.Close)
+	s := NewRedisStorage(resp.NewClient(srv.Addr()))
+	t.Cleanup(func() {
+		require.NoError(t, s.Close(context.Background()))
+	})
+	return s, srv
+}
+
+func TestRedisStorage_InsertGet(t *testing.T) {
+	s, srv := newRedisStorage(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		m    Metric
+		want Metric
+		name string
+		key  MetricName
+	}{
+		{
+			name: "insert counter",
+			key:  "test",
+			m:    NewMetricCounter(int64(3)),
+			want: NewMetricCounter(int64(3)),
+		},
+		{
+			name: "insert counter adds to stored value",
+			key:  "test",
+			m:    NewMetricCounter(int64(2)),
+			want: NewMetricCounter(int64(5)),
+		},
+		{
+			name: "insert gauge",
+			key:  "gtest",
+			m:    NewMetricGauge(float64(1.5)),
+			want: NewMetricGauge(float64(1.5)),
+		},
+		{
+			name: "insert gauge replaces stored value",
+			key:  "gtest",
+			m:    NewMetricGauge(float64(-0.25)),
+			want: NewMetricGauge(float64(-0.25)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, s.Insert(ctx, tt.key, tt.m))
+			m, err := s.Get(ctx, tt.key)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, m, "метрика в хранилище не соответствует ожидаемой")
+		})
+	}
+
+	m, err := s.Get(ctx, "unknown")
+	require.NoError(t, err)
+	require.Nil(t, m)
+
+	v, ok := srv.HGet("metrics:counter", "test")
+	require.True(t, ok)
+	require.Equal(t, "5", v)
+}
+
+func TestRedisStorage_Update(t *testing.T) {
+	s, _ := newRedisStorage(t)
+	ctx := context.Background()
+
+	counter := NewMetricCounter(int64(1))
+	require.NoError(t, s.Insert(ctx, "test", counter))
+	require.NoError(t, s.Update(ctx, "test", int64(4), counter))
+	require.Equal(t, int64(5), counter.GetValue())
+
+	gauge := NewMetricGauge(float64(1))
+	require.NoError(t, s.Insert(ctx, "gtest", gauge))
+	require.NoError(t, s.Update(ctx, "gtest", float64(2.5), gauge))
+	require.Equal(t, float64(2.5), gauge.GetValue())
+
+	all, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[MetricName]Metric{
+		"test":  NewMetricCounter(int64(5)),
+		"gtest": NewMetricGauge(float64(2.5)),
+	}, all)
+}
+
+func TestRedisStorage_BatchUpdate(t *testing.T) {
+	s, srv := newRedisStorage(t)
+	ctx := context.Background()
+
+	metrics := []Metrics{
+		{ID: "test", MType: "counter", ActualValue: int64(1)},
+		{ID: "test", MType: "counter", ActualValue: int64(2)},
+		{ID: "gtest", MType: "gauge", ActualValue: float64(0.1)},
+		{ID: "gtest", MType: "gauge", ActualValue: float64(0.2)},
+		//type of metric is changed
+		{ID: "changed", MType: "counter", ActualValue: int64(7)},
+	}
+	require.NoError(t, s.Insert(ctx, "changed", NewMetricGauge(float64(1))))
+	before := srv.Commands()
+	require.NoError(t, s.BatchUpdate(ctx, metrics))
+	//MULTI, two commands per collapsed metric and EXEC
+	require.Equal(t, 2+3*2, srv.Commands()-before)
+
+	all, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[MetricName]Metric{
+		"test":    NewMetricCounter(int64(3)),
+		"gtest":   NewMetricGauge(float64(0.2)),
+		"changed": NewMetricCounter(int64(7)),
+	}, all)
+
+	require.Error(t, s.BatchUpdate(ctx, []Metrics{{ID: "bad", ActualValue: "1"}}))
+}
+
+func TestRedisStorage_BatchSet(t *testing.T) {
+	s, _ := newRedisStorage(t)
+	ctx := context.Background()
+
+	require.NoError(t, s.BatchUpdate(ctx, []Metrics{
+		{ID: "test", ActualValue: int64(10)},
+		{ID: "changed", ActualValue: float64(1)},
+	}))
+	require.NoError(t, s.BatchSet(ctx, []Metrics{
+		{ID: "test", ActualValue: int64(1)},
+		{ID: "test", ActualValue: int64(3)},
+		{ID: "changed", ActualValue: int64(7)},
+	}))
+	all, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[MetricName]Metric{
+		"test":    NewMetricCounter(int64(3)),
+		"changed": NewMetricCounter(int64(7)),
+	}, all)
+}
+
+func TestRedisStorage_SharedBetweenInstances(t *testing.T) {
+	srv := resptest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	const instances, updates = 3, 50
+	var wg sync.WaitGroup
+	for i := 0; i < instances; i++ {
+		s := NewRedisStorage(resp.NewClient(srv.Addr()))
+		defer func() {
+			require.NoError(t, s.Close(ctx))
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				if err := s.BatchUpdate(ctx, []Metrics{{ID: "test", ActualValue: int64(1)}}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	m, err := NewRedisStorage(resp.NewClient(srv.Addr())).Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(instances*updates)), m)
+}
+
+func TestRedisStorage_Ping(t *testing.T) {
+	srv := resptest.NewServer()
+	srv.RequirePass("secret")
+	ctx := context.Background()
+
+	s := NewRedisStorage(resp.NewClient(srv.Addr()))
+	require.Error(t, s.Ping(ctx), "неаутентифицированный клиент должен получить ошибку")
+
+	s = NewRedisStorage(resp.NewClient(srv.Addr(), resp.OptionWithPassword("secret")))
+	require.NoError(t, s.Ping(ctx))
+
+	srv.Close()
+	require.NoError(t, s.Close(ctx))
+	require.Error(t, s.Ping(ctx))
+}
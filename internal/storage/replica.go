@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/esafronov/yp-metrics/internal/logger"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// replicaCheckInterval how long replica state is trusted before lag is measured again
+const replicaCheckInterval = 5 * time.Second
+
+// replicaCheckTimeout max duration of lag query, hung replica is considered unhealthy
+const replicaCheckTimeout = time.Second
+
+// replicaLagQuery returns replication lag in seconds, fully replayed replica has zero lag
+const replicaLagQuery string = `SELECT CASE
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END::DOUBLE PRECISION`
+
+// replica read-only database with cached health state
+type replica struct {
+	checkedAt time.Time
+	db        PgxPool
+	mu        sync.Mutex
+	healthy   bool
+	checking  bool //lag is being measured, other readers use last state meanwhile
+}
+
+// usable reports replica is reachable and its lag is within maxLag, measures lag if state is outdated.
+// Lag is measured by one reader at a time without holding lock, so other readers don't wait for it
+func (r *replica) usable(ctx context.Context, maxLag time.Duration) bool {
+	r.mu.Lock()
+	if r.checking || time.Since(r.checkedAt) < replicaCheckInterval {
+		healthy := r.healthy
+		r.mu.Unlock()
+		return healthy
+	}
+	r.checking = true
+	r.mu.Unlock()
+
+	healthy := r.checkLag(ctx, maxLag)
+	r.mu.Lock()
+	r.healthy = healthy
+	r.checkedAt = time.Now()
+	r.checking = false
+	r.mu.Unlock()
+	return healthy
+}
+
+// checkLag measures replication lag, replica is unhealthy if it doesn't respond in replicaCheckTimeout
+func (r *replica) checkLag(ctx context.Context, maxLag time.Duration) bool {
+	//canceled request must not mark replica unhealthy
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), replicaCheckTimeout)
+	defer cancel()
+	var lag float64
+	if err := r.db.QueryRow(ctx, replicaLagQuery).Scan(&lag); err != nil {
+		logger.Log.Info("replica lag check failed", zap.Error(err))
+		return false
+	}
+	if maxLag > 0 && time.Duration(lag*float64(time.Second)) > maxLag {
+		logger.Log.Info("replica lag is above threshold", zap.Float64("lag", lag))
+		return false
+	}
+	return true
+}
+
+// markFailed excludes replica from reading until next check
+func (r *replica) markFailed() {
+	r.mu.Lock()
+	r.healthy = false
+	r.checkedAt = time.Now()
+	r.mu.Unlock()
+}
+
+// OptionWithReplicas option function to configure DBStorage to read from replicas,
+// replica is skipped when its replication lag is above maxLag (0 = lag is not limited)
+func OptionWithReplicas(maxLag time.Duration, replicas ...PgxPool) func(s *DBStorage) {
+	return func(s *DBStorage) {
+		s.maxReplicaLag = maxLag
+		for _, db := range replicas {
+			s.replicas = append(s.replicas, &replica{db: db})
+		}
+	}
+}
+
+// pickReplica returns next usable replica in round robin order, nil if there is none
+func (s *DBStorage) pickReplica(ctx context.Context) *replica {
+	n := len(s.replicas)
+	if n == 0 {
+		return nil
+	}
+	start := int(s.nextReplica.Add(1))
+	for i := 0; i < n; i++ {
+		r := s.replicas[(start+i)%n]
+		if r.usable(ctx, s.maxReplicaLag) {
+			return r
+		}
+	}
+	return nil
+}
+
+// read runs read-only query f on replica, falls back to primary if there is no usable replica or replica fails
+func (s *DBStorage) read(ctx context.Context, op string, f func(db PgxPool) error) error {
+	if r := s.pickReplica(ctx); r != nil {
+		err := f(r.db)
+		if err == nil || errors.Is(err, pgx.ErrNoRows) || ctx.Err() != nil {
+			return err
+		}
+		logger.Log.Info("replica query failed, fallback to primary", zap.String("op", op), zap.Error(err))
+		r.markFailed()
+	}
+	return s.withRetry(ctx, op, true, func() error {
+		return f(s.db)
+	})
+}
+
+// closeReplicas closes replica pools
+func (s *DBStorage) closeReplicas() {
+	for _, r := range s.replicas {
+		r.db.Close()
+	}
+}
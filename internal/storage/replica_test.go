@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newReplicatedStorage(t *testing.T, maxLag time.Duration) (*DBStorage, pgxmock.PgxPoolIface, pgxmock.PgxPoolIface) {
+	primary, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	replicaPool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	s := &DBStorage{
+		db: primary,
+	}
+	OptionWithReplicas(maxLag, replicaPool)(s)
+	return s, primary, replicaPool
+}
+
+func TestDBStorage_ReadFromReplica(t *testing.T) {
+	s, primary, replicaPool := newReplicatedStorage(t, time.Second)
+	ctx := context.Background()
+
+	replicaPool.ExpectQuery("^SELECT CASE").
+		WillReturnRows(pgxmock.NewRows([]string{"lag"}).AddRow(float64(0)))
+	replicaPool.ExpectQuery("^SELECT value_gauge, value_counter").
+		WithArgs("test").
+		WillReturnRows(pgxmock.NewRows([]string{"value_gauge", "value_counter"}).AddRow(nil, int64(5)))
+	//lag is not checked again within check interval
+	replicaPool.ExpectQuery("SELECT metric_name, value_gauge, value_counter").
+		WillReturnRows(pgxmock.NewRows([]string{"metric_name", "value_gauge", "value_counter"}).AddRow("test", nil, int64(5)))
+
+	m, err := s.Get(ctx, "test")
+	require.NoError(t, err)
+	require.Equal(t, NewMetricCounter(int64(5)), m)
+	all, err := s.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+
+	require.NoError(t, replicaPool.ExpectationsWereMet())
+	require.NoError(t, primary.ExpectationsWereMet())
+}
+
+func TestDBStorage_ReplicaFallback(t *testing.T) {
+	tests := []struct {
+		prepare func(replicaPool pgxmock.PgxPoolIface)
+		name    string
+	}{
+		{
+			name: "replica lag above threshold",
+			prepare: func(replicaPool pgxmock.PgxPoolIface) {
+				replicaPool.ExpectQuery("^SELECT CASE").
+					WillReturnRows(pgxmock.NewRows([]string{"lag"}).AddRow(float64(10)))
+			},
+		},
+		{
+			name: "replica lag check failed",
+			prepare: func(replicaPool pgxmock.PgxPoolIface) {
+				replicaPool.ExpectQuery("^SELECT CASE").
+					WillReturnError(errors.New("connection refused"))
+			},
+		},
+		{
+			name: "replica query failed",
+			prepare: func(replicaPool pgxmock.PgxPoolIface) {
+				replicaPool.ExpectQuery("^SELECT CASE").
+					WillReturnRows(pgxmock.NewRows([]string{"lag"}).AddRow(float64(0)))
+				replicaPool.ExpectQuery("^SELECT value_gauge, value_counter").
+					WithArgs("test").
+					WillReturnError(errors.New("connection reset"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, primary, replicaPool := newReplicatedStorage(t, time.Second)
+			tt.prepare(replicaPool)
+			primary.ExpectQuery("^SELECT value_gauge, value_counter").
+				WithArgs("test").
+				WillReturnRows(pgxmock.NewRows([]string{"value_gauge", "value_counter"}).AddRow(0.5, nil))
+
+			m, err := s.Get(context.Background(), "test")
+			require.NoError(t, err)
+			require.Equal(t, NewMetricGauge(0.5), m)
+			require.NoError(t, replicaPool.ExpectationsWereMet())
+			require.NoError(t, primary.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestReplica_usableDuringCheck(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	r := &replica{db: pool, healthy: true}
+	pool.ExpectQuery("^SELECT CASE").
+		WillReturnRows(pgxmock.NewRows([]string{"lag"}).AddRow(float64(10))).
+		WillDelayFor(200 * time.Millisecond)
+
+	done := make(chan bool)
+	go func() {
+		done <- r.usable(context.Background(), time.Second)
+	}()
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.checking
+	}, time.Second, time.Millisecond)
+	//other reader gets last state without waiting for lag query
+	start := time.Now()
+	require.True(t, r.usable(context.Background(), time.Second))
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	require.False(t, <-done)
+	require.False(t, r.usable(context.Background(), time.Second))
+	require.NoError(t, pool.ExpectationsWereMet())
+}
+
+func TestReplica_usableHung(t *testing.T) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	r := &replica{db: pool, healthy: true}
+	pool.ExpectQuery("^SELECT CASE").
+		WillReturnRows(pgxmock.NewRows([]string{"lag"}).AddRow(float64(0))).
+		WillDelayFor(time.Minute)
+
+	start := time.Now()
+	require.False(t, r.usable(context.Background(), time.Second))
+	require.Less(t, time.Since(start), 2*replicaCheckTimeout)
+}
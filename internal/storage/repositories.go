@@ -15,4 +15,8 @@ type Repositories interface {
 	Close(context.Context) error
 	//update multiple entries
 	BatchUpdate(context.Context, []Metrics) error
+	//set absolute values of multiple entries, counters are replaced rather than incremented
+	BatchSet(context.Context, []Metrics) error
+	//check repository backend is available
+	Ping(context.Context) error
 }
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/esafronov/yp-metrics/internal/tenant"
+)
+
+// tenantSeparator separates tenant from metric name in wrapped storage, names containing it are rejected
+const tenantSeparator string = "/"
+
+var (
+	ErrMetricNameReserved = errors.New("metric name contains reserved separator " + tenantSeparator)
+	ErrQuotaExceeded      = errors.New("tenant metric quota is exceeded")
+)
+
+// TenantStorage isolates metrics of tenants in wrapped storage. Tenant is taken from context,
+// its metrics are stored under "<tenant>/<name>", metrics of Default tenant are stored under plain names.
+// Quota limits number of distinct metrics per tenant, names are counted by this instance,
+// so servers sharing storage may together exceed quota by metrics created elsewhere
+type TenantStorage struct {
+	Repositories                        //wrapped storage with metrics of all tenants
+	names        map[tenant.ID]*nameSet //known metric names of tenant, loaded on first write
+	mu           sync.Mutex
+	quota        int //max metrics per tenant, 0 = not limited
+}
+
+// OptionWithTenantQuota option function to configure max number of metrics per tenant
+func OptionWithTenantQuota(quota int) func(s *TenantStorage) {
+	return func(s *TenantStorage) {
+		s.quota = quota
+	}
+}
+
+// NewTenantStorage is factory method
+func NewTenantStorage(next Repositories, opts ...func(s *TenantStorage)) *TenantStorage {
+	s := &TenantStorage{
+		Repositories: next,
+		names:        make(map[tenant.ID]*nameSet),
+	}
+	for _, f := range opts {
+		f(s)
+	}
+	return s
+}
+
+// tenantKey returns name of metric in wrapped storage
+func tenantKey(id tenant.ID, name MetricName) (MetricName, error) {
+	if strings.Contains(string(name), tenantSeparator) {
+		return "", ErrMetricNameReserved
+	}
+	if id == tenant.Default {
+		return name, nil
+	}
+	return MetricName(string(id) + tenantSeparator + string(name)), nil
+}
+
+// ownName returns tenant's name of metric stored under key, false if metric belongs to other tenant
+func ownName(id tenant.ID, key MetricName) (MetricName, bool) {
+	if id == tenant.Default {
+		return key, !strings.Contains(string(key), tenantSeparator)
+	}
+	name, ok := strings.CutPrefix(string(key), string(id)+tenantSeparator)
+	return MetricName(name), ok
+}
+
+func (s *TenantStorage) Get(ctx context.Context, name MetricName) (Metric, error) {
+	k, err := tenantKey(tenant.FromContext(ctx), name)
+	if err != nil {
+		//metric with such name can't exist
+		return nil, nil
+	}
+	return s.Repositories.Get(ctx, k)
+}
+
+func (s *TenantStorage) Insert(ctx context.Context, name MetricName, m Metric) error {
+	id := tenant.FromContext(ctx)
+	k, err := tenantKey(id, name)
+	if err != nil {
+		return err
+	}
+	done, err := s.reserve(ctx, id, []MetricName{name})
+	if err != nil {
+		return err
+	}
+	err = s.Repositories.Insert(ctx, k, m)
+	done(err == nil)
+	return err
+}
+
+func (s *TenantStorage) Update(ctx context.Context, name MetricName, v interface{}, metric Metric) error {
+	k, err := tenantKey(tenant.FromContext(ctx), name)
+	if err != nil {
+		return err
+	}
+	return s.Repositories.Update(ctx, k, v, metric)
+}
+
+func (s *TenantStorage) BatchUpdate(ctx context.Context, metrics []Metrics) error {
+	batch, done, err := s.tenantBatch(ctx, metrics)
+	if err != nil {
+		return err
+	}
+	err = s.Repositories.BatchUpdate(ctx, batch)
+	done(err == nil)
+	return err
+}
+
+func (s *TenantStorage) BatchSet(ctx context.Context, metrics []Metrics) error {
+	batch, done, err := s.tenantBatch(ctx, metrics)
+	if err != nil {
+		return err
+	}
+	err = s.Repositories.BatchSet(ctx, batch)
+	done(err == nil)
+	return err
+}
+
+// tenantBatch returns batch with names of wrapped storage, names are reserved in tenant quota until done is called
+func (s *TenantStorage) tenantBatch(ctx context.Context, metrics []Metrics) ([]Metrics, func(applied bool), error) {
+	id := tenant.FromContext(ctx)
+	batch := make([]Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		k, err := tenantKey(id, MetricName(m.ID))
+		if err != nil {
+			return nil, nil, fmt.Errorf("metric %q: %w", m.ID, err)
+		}
+		m.ID = string(k)
+		batch = append(batch, m)
+	}
+	done, err := s.reserve(ctx, id, batchNames(metrics))
+	if err != nil {
+		return nil, nil, err
+	}
+	return batch, done, nil
+}
+
+// GetAll returns metrics of tenant under their own names
+func (s *TenantStorage) GetAll(ctx context.Context) (map[MetricName]Metric, error) {
+	return s.tenantMetrics(ctx, tenant.FromContext(ctx))
+}
+
+func (s *TenantStorage) tenantMetrics(ctx context.Context, id tenant.ID) (map[MetricName]Metric, error) {
+	all, err := s.Repositories.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make(map[MetricName]Metric)
+	for k, m := range all {
+		if name, ok := ownName(id, k); ok {
+			metrics[name] = m
+		}
+	}
+	return metrics, nil
+}
+
+// reserve checks quota and reserves new metric names of tenant, whole batch is rejected if it doesn't fit.
+// Returned done must be called with result of write, names of failed write are released
+func (s *TenantStorage) reserve(ctx context.Context, id tenant.ID, names []MetricName) (func(applied bool), error) {
+	if s.quota <= 0 {
+		return func(bool) {}, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known, ok := s.names[id]
+	if !ok {
+		metrics, err := s.tenantMetrics(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		known = newNameSet(metrics)
+		s.names[id] = known
+	}
+	reserved, ok := known.reserve(names, s.quota)
+	if !ok {
+		return nil, fmt.Errorf("%w: tenant %q, quota %d", ErrQuotaExceeded, id, s.quota)
+	}
+	return func(applied bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		known.done(reserved, applied)
+	}, nil
+}
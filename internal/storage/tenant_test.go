@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/esafronov/yp-metrics/internal/tenant"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantStorage_Isolation(t *testing.T) {
+	mem := NewMemStorage()
+	s := NewTenantStorage(mem)
+	defaultCtx := context.Background()
+	teamA := tenant.NewContext(defaultCtx, "team-a")
+	teamB := tenant.NewContext(defaultCtx, "team-b")
+
+	require.NoError(t, s.Insert(defaultCtx, "test", NewMetricCounter(int64(1))))
+	require.NoError(t, s.Insert(teamA, "test", NewMetricCounter(int64(10))))
+	require.NoError(t, s.BatchUpdate(teamB, []Metrics{
+		{ID: "test", ActualValue: int64(100)},
+		{ID: "gtest", ActualValue: float64(0.5)},
+	}))
+	m, err := s.Get(teamA, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.Update(teamA, "test", int64(5), m))
+
+	tests := []struct {
+		ctx  context.Context
+		want map[MetricName]Metric
+		name string
+	}{
+		{
+			name: "default tenant",
+			ctx:  defaultCtx,
+			want: map[MetricName]Metric{"test": NewMetricCounter(int64(1))},
+		},
+		{
+			name: "team-a",
+			ctx:  teamA,
+			want: map[MetricName]Metric{"test": NewMetricCounter(int64(15))},
+		},
+		{
+			name: "team-b",
+			ctx:  teamB,
+			want: map[MetricName]Metric{
+				"test":  NewMetricCounter(int64(100)),
+				"gtest": NewMetricGauge(float64(0.5)),
+			},
+		},
+		{
+			name: "tenant without metrics",
+			ctx:  tenant.NewContext(defaultCtx, "team-c"),
+			want: map[MetricName]Metric{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all, err := s.GetAll(tt.ctx)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, all)
+			for name, want := range tt.want {
+				m, err := s.Get(tt.ctx, name)
+				require.NoError(t, err)
+				require.Equal(t, want, m)
+			}
+		})
+	}
+
+	//wrapped storage keeps metrics of tenants under prefixed names
+	require.Equal(t, NewMetricCounter(int64(15)), storedMetric(t, mem, "team-a/test"))
+}
+
+func TestTenantStorage_ReservedName(t *testing.T) {
+	s := NewTenantStorage(NewMemStorage())
+	ctx := tenant.NewContext(context.Background(), "team-a")
+
+	require.ErrorIs(t, s.Insert(context.Background(), "team-a/test", NewMetricCounter(int64(1))), ErrMetricNameReserved)
+	require.ErrorIs(t, s.BatchUpdate(ctx, []Metrics{{ID: "a/b", ActualValue: int64(1)}}), ErrMetricNameReserved)
+	m, err := s.Get(ctx, "a/b")
+	require.NoError(t, err)
+	require.Nil(t, m)
+}
+
+func TestTenantStorage_Quota(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemStorageWithValues(map[MetricName]Metric{
+		"team-a/existing": NewMetricCounter(int64(1)),
+	})
+	s := NewTenantStorage(mem, OptionWithTenantQuota(2))
+	teamA := tenant.NewContext(ctx, "team-a")
+
+	//stored metrics are counted
+	require.NoError(t, s.Insert(teamA, "first", NewMetricCounter(int64(1))))
+	require.ErrorIs(t, s.Insert(teamA, "second", NewMetricCounter(int64(1))), ErrQuotaExceeded)
+	//batch is rejected as a whole
+	require.ErrorIs(t, s.BatchUpdate(teamA, []Metrics{
+		{ID: "first", ActualValue: int64(1)},
+		{ID: "third", ActualValue: int64(1)},
+	}), ErrQuotaExceeded)
+	require.Equal(t, NewMetricCounter(int64(1)), storedMetric(t, mem, "team-a/first"))
+	//updates of known metrics are not limited
+	require.NoError(t, s.BatchUpdate(teamA, []Metrics{
+		{ID: "first", ActualValue: int64(1)},
+		{ID: "existing", ActualValue: int64(1)},
+	}))
+
+	//quota is per tenant
+	teamB := tenant.NewContext(ctx, "team-b")
+	require.NoError(t, s.BatchUpdate(teamB, []Metrics{
+		{ID: "first", ActualValue: int64(1)},
+		{ID: "second", ActualValue: int64(1)},
+	}))
+}
+
+// failingStorage fails writes while err is set
+type failingStorage struct {
+	*MemStorage
+	err error
+}
+
+func (f *failingStorage) Insert(ctx context.Context, key MetricName, m Metric) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.MemStorage.Insert(ctx, key, m)
+}
+
+func (f *failingStorage) BatchUpdate(ctx context.Context, metrics []Metrics) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.MemStorage.BatchUpdate(ctx, metrics)
+}
+
+func (f *failingStorage) BatchSet(ctx context.Context, metrics []Metrics) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.MemStorage.BatchSet(ctx, metrics)
+}
+
+func TestTenantStorage_QuotaFailedWrite(t *testing.T) {
+	inner := &failingStorage{MemStorage: NewMemStorage(), err: errors.New("connection reset")}
+	s := NewTenantStorage(inner, OptionWithTenantQuota(2))
+	teamA := tenant.NewContext(context.Background(), "team-a")
+
+	//names of failed writes don't take quota
+	require.ErrorIs(t, s.Insert(teamA, "first", NewMetricCounter(int64(1))), inner.err)
+	require.ErrorIs(t, s.BatchUpdate(teamA, []Metrics{
+		{ID: "second", ActualValue: int64(1)},
+		{ID: "third", ActualValue: int64(1)},
+	}), inner.err)
+	require.ErrorIs(t, s.BatchSet(teamA, []Metrics{
+		{ID: "fourth", ActualValue: int64(1)},
+	}), inner.err)
+
+	inner.err = nil
+	require.NoError(t, s.BatchUpdate(teamA, []Metrics{
+		{ID: "fifth", ActualValue: int64(1)},
+		{ID: "sixth", ActualValue: int64(1)},
+	}))
+	require.ErrorIs(t, s.Insert(teamA, "first", NewMetricCounter(int64(1))), ErrQuotaExceeded)
+}
+
+func TestTenantStorage_BatchSet(t *testing.T) {
+	mem := NewMemStorageWithValues(map[MetricName]Metric{
+		"test":        NewMetricCounter(int64(1)),
+		"team-a/test": NewMetricCounter(int64(10)),
+	})
+	s := NewTenantStorage(mem)
+	require.NoError(t, s.BatchSet(tenant.NewContext(context.Background(), "team-a"), []Metrics{
+		{ID: "test", ActualValue: int64(3)},
+	}))
+	require.Equal(t, NewMetricCounter(int64(3)), storedMetric(t, mem, "team-a/test"))
+	require.Equal(t, NewMetricCounter(int64(1)), storedMetric(t, mem, "test"))
+}
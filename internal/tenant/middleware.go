@@ -0,0 +1,83 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Middleware server middleware putting tenant of request into request context
+func Middleware(r *Resolver) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			id, err := r.Resolve(req.Header.Get(HeaderTenantID), req.Header.Get(HeaderAuthorization))
+			if err != nil {
+				code := http.StatusUnauthorized
+				if errors.Is(err, ErrInvalidTenant) {
+					code = http.StatusBadRequest
+				}
+				http.Error(w, err.Error(), code)
+				return
+			}
+			h.ServeHTTP(w, req.WithContext(NewContext(req.Context(), id)))
+		})
+	}
+}
+
+// UnaryServerInterceptor is the interceptor for gRPC server putting tenant from metadata into context
+func UnaryServerInterceptor(r *Resolver) func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := r.fromMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the stream interceptor for gRPC server putting tenant from metadata into context
+func StreamServerInterceptor(r *Resolver) func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := r.fromMetadata(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &tenantStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// fromMetadata returns context with tenant resolved by incoming metadata
+func (r *Resolver) fromMetadata(ctx context.Context) (context.Context, error) {
+	var header, authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(MetadataTenantID); len(values) > 0 {
+			header = values[0]
+		}
+		if values := md.Get(MetadataAuthorization); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	id, err := r.Resolve(header, authorization)
+	if err != nil {
+		if errors.Is(err, ErrInvalidTenant) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return NewContext(ctx, id), nil
+}
+
+// tenantStream server stream with context replaced
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tenantStream) Context() context.Context {
+	return s.ctx
+}
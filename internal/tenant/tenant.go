@@ -0,0 +1,140 @@
+// Package tenant implements identification of tenant which request belongs to.
+// Tenant is taken from X-Tenant-ID header, JWT claim or gRPC metadata and passed to storage in context
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ID tenant identifier, metrics of different tenants are isolated
+type ID string
+
+// Default tenant of requests without tenant, its metrics are stored under plain names
+const Default ID = ""
+
+// HeaderTenantID http header with tenant identifier
+const HeaderTenantID string = "X-Tenant-ID"
+
+// HeaderAuthorization http header with bearer JWT
+const HeaderAuthorization string = "Authorization"
+
+// MetadataTenantID gRPC metadata key with tenant identifier
+const MetadataTenantID string = "x-tenant-id"
+
+// MetadataAuthorization gRPC metadata key with bearer JWT
+const MetadataAuthorization string = "authorization"
+
+// ClaimTenant JWT claim with tenant identifier
+const ClaimTenant string = "tenant"
+
+var (
+	ErrInvalidTenant = errors.New("tenant id is invalid")
+	ErrUnauthorized  = errors.New("tenant token is missing or invalid")
+)
+
+// MaxIDLength max length of tenant identifier
+const MaxIDLength int = 64
+
+// validID allowed tenant identifiers
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,` + strconv.Itoa(MaxIDLength) + `}$`)
+
+type ctxKey struct{}
+
+// NewContext returns context carrying tenant
+func NewContext(ctx context.Context, id ID) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns tenant of context, Default if context has no tenant
+func FromContext(ctx context.Context) ID {
+	id, _ := ctx.Value(ctxKey{}).(ID)
+	return id
+}
+
+// Resolver determines tenant of request
+type Resolver struct {
+	jwtKey    []byte //key of HMAC signed tokens, tenant header is not trusted if it is set
+	anonymous bool   //requests without token belong to Default tenant if JWT key is set
+}
+
+// OptionWithJWTKey option function to configure Resolver to take tenant from signed JWT only
+func OptionWithJWTKey(key string) func(r *Resolver) {
+	return func(r *Resolver) {
+		if key != "" {
+			r.jwtKey = []byte(key)
+		}
+	}
+}
+
+// OptionWithAnonymous option function to configure Resolver to accept requests without token as Default tenant
+// when JWT key is set, otherwise they are unauthorized
+func OptionWithAnonymous(anonymous bool) func(r *Resolver) {
+	return func(r *Resolver) {
+		r.anonymous = anonymous
+	}
+}
+
+// NewResolver is factory method
+func NewResolver(opts ...func(r *Resolver)) *Resolver {
+	r := &Resolver{}
+	for _, f := range opts {
+		f(r)
+	}
+	return r
+}
+
+// Resolve returns tenant by values of tenant header and authorization header.
+// Without JWT key tenant header is used as is. With JWT key tenant is taken from claim of bearer token,
+// tenant header must match it. Request without token and tenant header is unauthorized
+// unless anonymous requests are allowed, then it belongs to Default tenant
+func (r *Resolver) Resolve(header string, authorization string) (ID, error) {
+	if r.jwtKey == nil {
+		if header == "" {
+			return Default, nil
+		}
+		return parseID(header)
+	}
+	if authorization == "" {
+		if header == "" && r.anonymous {
+			return Default, nil
+		}
+		return Default, ErrUnauthorized
+	}
+	raw, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return Default, ErrUnauthorized
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return r.jwtKey, nil
+	})
+	if err != nil {
+		return Default, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	claim, _ := claims[ClaimTenant].(string)
+	id, err := parseID(claim)
+	if err != nil {
+		return Default, err
+	}
+	if header != "" && ID(header) != id {
+		return Default, ErrUnauthorized
+	}
+	return id, nil
+}
+
+func parseID(s string) (ID, error) {
+	if !validID.MatchString(s) {
+		return Default, ErrInvalidTenant
+	}
+	return ID(s), nil
+}
@@ -0,0 +1,133 @@
+package tenant
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func signedToken(t *testing.T, key string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+	require.NoError(t, err)
+	return "Bearer " + token
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	const key = "secret"
+	valid := signedToken(t, key, jwt.MapClaims{ClaimTenant: "team-a"})
+	tests := []struct {
+		name          string
+		header        string
+		authorization string
+		jwtKey        string
+		want          ID
+		wantErr       error
+		anonymous     bool
+	}{
+		{name: "no tenant", want: Default},
+		{name: "tenant header", header: "team-a", want: "team-a"},
+		{name: "invalid tenant header", header: "team/a", wantErr: ErrInvalidTenant},
+		{name: "too long tenant header", header: string(make([]byte, 65)), wantErr: ErrInvalidTenant},
+		{name: "jwt without token is unauthorized", jwtKey: key, wantErr: ErrUnauthorized},
+		{name: "jwt without token is default tenant if anonymous allowed", jwtKey: key, anonymous: true, want: Default},
+		{name: "anonymous request can't choose tenant", jwtKey: key, anonymous: true, header: "team-a", wantErr: ErrUnauthorized},
+		{name: "jwt claim", jwtKey: key, authorization: valid, want: "team-a"},
+		{name: "jwt claim matches header", jwtKey: key, authorization: valid, header: "team-a", want: "team-a"},
+		{name: "jwt claim differs from header", jwtKey: key, authorization: valid, header: "team-b", wantErr: ErrUnauthorized},
+		{name: "header without token is not trusted", jwtKey: key, header: "team-a", wantErr: ErrUnauthorized},
+		{
+			name:          "token signed with other key",
+			jwtKey:        key,
+			authorization: signedToken(t, "other", jwt.MapClaims{ClaimTenant: "team-a"}),
+			wantErr:       ErrUnauthorized,
+		},
+		{
+			name:          "expired token",
+			jwtKey:        key,
+			authorization: signedToken(t, key, jwt.MapClaims{ClaimTenant: "team-a", "exp": time.Now().Add(-time.Minute).Unix()}),
+			wantErr:       ErrUnauthorized,
+		},
+		{
+			name:          "token without tenant claim",
+			jwtKey:        key,
+			authorization: signedToken(t, key, jwt.MapClaims{"sub": "user"}),
+			wantErr:       ErrInvalidTenant,
+		},
+		{name: "not bearer authorization", jwtKey: key, authorization: "Basic dXNlcg==", wantErr: ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewResolver(OptionWithJWTKey(tt.jwtKey), OptionWithAnonymous(tt.anonymous))
+			got, err := r.Resolve(tt.header, tt.authorization)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got ID
+	h := Middleware(NewResolver())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderTenantID, "team-a")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, ID("team-a"), got)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderTenantID, "team a")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	h = Middleware(NewResolver(OptionWithJWTKey("secret")))(h)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderTenantID, "team-a")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	//request without any credentials doesn't get access to default tenant
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(NewResolver())
+	handler := func(ctx context.Context, req any) (any, error) {
+		return FromContext(ctx), nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataTenantID, "team-a"))
+	got, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	require.Equal(t, ID("team-a"), got)
+
+	got, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	require.Equal(t, Default, got)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataTenantID, "team/a"))
+	_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}